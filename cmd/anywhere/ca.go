@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/pflag"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/config"
+	"github.com/AyakuraYuki/go-anywhere/internal/core"
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// runCA runs the "anywhere ca" subcommands and returns the exit code.
+func runCA(args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "--help" || args[0] == "-h" {
+		printCAHelp()
+		return 0
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "info":
+		return caInfo(args)
+	case "export":
+		return caExport(args)
+	case "rotate":
+		return caRotate(args)
+	case "path":
+		return caPath(args)
+	case "verify":
+		return caVerify(args)
+	case "client":
+		return caClient(args)
+	default:
+		log.Error().Str("scope", "ca").Msgf("unknown command %q", cmd)
+		printCAHelp()
+		return 2
+	}
+}
+
+func printCAHelp() {
+	fmt.Println(`anywhere ca - Manage the local root CA of the HTTPS server
+
+Usage:
+  anywhere ca <command> [options]
+
+Commands:
+  info                    Show the fingerprint, expiry and trust status
+  export                  Export the CA certificate
+    --format <format>     pem, der or p12 (default: from --out, else pem)
+    -o, --out <file>      Output file (default: stdout)
+    --with-key            Include the private key (pem and p12 only)
+    --password <pass>     Password of the p12 file
+  rotate --yes            Replace the CA with a new key and reinstall it, the
+                          old CA and its certificates are no longer trusted
+    --constrain           Limit the new CA with name constraints to private
+                          IPs, localhost, .test and .local
+    --domain <domain>     Additional domain permitted by --constrain, with
+                          its subdomains, repeatable
+  path                    Print the CA certificate path
+    --key                 Print the private key path instead
+    --dir                 Print the CA directory instead
+  verify [addr|file]      Verify that the certificate served at addr, or the
+                          PEM/DER certificate file, is issued by the CA
+                          (default: localhost:8001)
+    --host <name>         Name to verify the certificate of file for
+  client <name>           Issue a client certificate for --mtls, written as
+                          <name>.pem, <name>.key and <name>.p12
+    -o, --out <dir>       Output directory (default: current directory)
+    --days <days>         Validity in days (default: 365)
+    --password <pass>     Password of the p12 file
+
+Examples:
+  anywhere ca info
+  anywhere ca export -o rootCA.cer             # DER, e.g. for Android
+  anywhere ca export -o rootCA.p12 --password secret
+  anywhere ca rotate --yes --constrain --domain dev.example.com
+  anywhere ca verify 192.168.1.10:8001
+  anywhere ca client alice-phone --password secret`)
+}
+
+func caInfo(args []string) int {
+	fs := newCAFlagSet("info")
+	if !parseCAFlags(fs, args) {
+		return 2
+	}
+
+	info, err := core.LoadCAInfo()
+	if err != nil {
+		log.Error().Str("scope", "ca").Err(err).Msg("Cannot read root CA")
+		return 1
+	}
+
+	trusted := "no, run: anywhere --install-ca"
+	if info.Trusted {
+		trusted = "yes"
+	}
+
+	constraints := "none, run: anywhere ca rotate --yes --constrain"
+	if len(info.Constraints) > 0 {
+		constraints = strings.Join(info.Constraints, "\n")
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendRows([]table.Row{
+		{"Subject", info.Subject},
+		{"Serial", info.Serial},
+		{"SHA-256", info.Fingerprint},
+		{"Valid from", info.NotBefore.Local().Format(time.DateTime)},
+		{"Expires", fmt.Sprintf("%s (in %d days)", info.NotAfter.Local().Format(time.DateTime), int(time.Until(info.NotAfter).Hours()/24))},
+		{"Trusted", trusted},
+		{"Constraints", constraints},
+		{"Certificate", info.CertPath},
+		{"Key", info.KeyPath},
+	})
+	t.Render()
+
+	return 0
+}
+
+func caExport(args []string) int {
+	var (
+		format   string
+		out      string
+		withKey  bool
+		password string
+	)
+
+	fs := newCAFlagSet("export")
+	fs.StringVar(&format, "format", "", "pem, der or p12")
+	fs.StringVarP(&out, "out", "o", "", "output file")
+	fs.BoolVar(&withKey, "with-key", false, "include the private key")
+	fs.StringVar(&password, "password", "", "password of the p12 file")
+	if !parseCAFlags(fs, args) {
+		return 2
+	}
+
+	if format == "" {
+		format = exportFormat(out)
+	}
+
+	data, err := core.ExportCA(strings.ToLower(format), withKey, password)
+	if err != nil {
+		log.Error().Str("scope", "ca").Err(err).Msg("Cannot export root CA")
+		return 1
+	}
+
+	if out == "" {
+		_, _ = os.Stdout.Write(data)
+		return 0
+	}
+
+	perm := os.FileMode(0644)
+	if withKey {
+		perm = 0600
+	}
+	if err = os.WriteFile(out, data, perm); err != nil {
+		log.Error().Str("scope", "ca").Err(err).Msg("Cannot write export file")
+		return 1
+	}
+	log.Info().Str("scope", "ca").Msgf("Root CA exported to %s", out)
+
+	return 0
+}
+
+// exportFormat guesses the export format from the output file extension.
+func exportFormat(out string) string {
+	switch strings.ToLower(filepath.Ext(out)) {
+	case ".der", ".cer":
+		return core.ExportDER
+	case ".p12", ".pfx":
+		return core.ExportPKCS12
+	default:
+		return core.ExportPEM
+	}
+}
+
+func caRotate(args []string) int {
+	var (
+		yes  bool
+		opts core.CAOptions
+	)
+
+	fs := newCAFlagSet("rotate")
+	fs.BoolVar(&yes, "yes", false, "confirm the rotation")
+	fs.BoolVar(&opts.Constrained, "constrain", false, "limit the new CA with name constraints")
+	fs.StringArrayVar(&opts.Domains, "domain", nil, "domain permitted by the name constraints, repeatable")
+	if !parseCAFlags(fs, args) {
+		return 2
+	}
+	if len(opts.Domains) > 0 && !opts.Constrained {
+		log.Error().Str("scope", "ca").Msg("--domain requires --constrain")
+		return 2
+	}
+	for i, domain := range opts.Domains {
+		normalized, ok := config.CADomain(domain)
+		if !ok {
+			log.Error().Str("scope", "ca").Msgf("invalid domain %q (expected a DNS name like example.dev)", domain)
+			return 2
+		}
+		opts.Domains[i] = normalized
+	}
+
+	if !yes {
+		log.Warn().Str("scope", "ca").Msg(`Rotating replaces the root CA with a new key. Every device and browser
+  that trusts the current CA has to trust the new one again.
+  Run "anywhere ca rotate --yes" to continue.`)
+		return 1
+	}
+
+	info, err := core.RotateCA(opts)
+	if err != nil {
+		log.Error().Str("scope", "ca").Err(err).Msg("Cannot rotate root CA")
+		return 1
+	}
+	log.Info().Str("scope", "ca").Msgf("New root CA %s created at %s", info.Fingerprint, info.CertPath)
+
+	return 0
+}
+
+func caPath(args []string) int {
+	var key, dir bool
+
+	fs := newCAFlagSet("path")
+	fs.BoolVar(&key, "key", false, "print the private key path")
+	fs.BoolVar(&dir, "dir", false, "print the CA directory")
+	if !parseCAFlags(fs, args) {
+		return 2
+	}
+
+	certPath, keyPath := core.CAPaths()
+	switch {
+	case dir:
+		fmt.Println(filepath.Dir(certPath))
+	case key:
+		fmt.Println(keyPath)
+	default:
+		fmt.Println(certPath)
+	}
+
+	return 0
+}
+
+func caVerify(args []string) int {
+	var host string
+
+	fs := newCAFlagSet("verify")
+	fs.StringVar(&host, "host", "", "name to verify the certificate of a file for")
+	if !parseCAFlags(fs, args) {
+		return 2
+	}
+
+	target := "localhost:8001"
+	if fs.NArg() > 0 {
+		target = fs.Arg(0)
+	}
+
+	var (
+		chain []*x509.Certificate
+		err   error
+	)
+	if _, statErr := os.Stat(target); statErr == nil {
+		chain, err = core.ReadChain(target)
+	} else {
+		if host == "" {
+			host, _, _ = net.SplitHostPort(target)
+		}
+		chain, err = core.FetchChain(target)
+	}
+	if err != nil {
+		log.Error().Str("scope", "ca").Err(err).Msgf("Cannot read certificate of %s", target)
+		return 1
+	}
+
+	verified, err := core.VerifyChain(chain, host)
+	if err != nil {
+		if errors.Is(err, core.ErrNoCA) {
+			log.Error().Str("scope", "ca").Err(err).Msg("Cannot verify")
+		} else {
+			log.Error().Str("scope", "ca").Err(err).Msgf("Certificate of %s is not issued by the local root CA", target)
+		}
+		return 1
+	}
+
+	for i, cert := range verified {
+		indent := strings.Repeat("  ", i)
+		name := cert.Subject.CommonName
+		if len(cert.DNSNames) > 0 {
+			name += " (" + strings.Join(cert.DNSNames, ", ") + ")"
+		}
+		fmt.Printf("%s%s\n%s  SHA-256 %s, expires %s\n",
+			indent, name, indent, core.Fingerprint(cert), cert.NotAfter.Local().Format(time.DateOnly))
+	}
+	fmt.Printf("OK: %s chains to the local root CA\n", target)
+
+	return 0
+}
+
+func caClient(args []string) int {
+	var (
+		out      string
+		days     int
+		password string
+	)
+
+	fs := newCAFlagSet("client")
+	fs.StringVarP(&out, "out", "o", ".", "output directory")
+	fs.IntVar(&days, "days", 365, "validity in days")
+	fs.StringVar(&password, "password", "", "password of the p12 file")
+	if !parseCAFlags(fs, args) {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		log.Error().Str("scope", "ca").Msg("Usage: anywhere ca client <name>")
+		return 2
+	}
+	name := fs.Arg(0)
+
+	client, err := core.IssueClientCert(name, days, password)
+	if err != nil {
+		log.Error().Str("scope", "ca").Err(err).Msg("Cannot issue client certificate")
+		return 1
+	}
+
+	base := filepath.Join(out, clientFileName(name))
+	for _, file := range []struct {
+		path string
+		data []byte
+		perm os.FileMode
+	}{
+		{base + ".pem", client.CertPEM, 0644},
+		{base + ".key", client.KeyPEM, 0600},
+		{base + ".p12", client.PKCS12, 0600},
+	} {
+		if err = os.WriteFile(file.path, file.data, file.perm); err != nil {
+			log.Error().Str("scope", "ca").Err(err).Msg("Cannot write client certificate")
+			return 1
+		}
+	}
+
+	log.Info().Str("scope", "ca").Msgf("Client certificate %q issued, valid until %s: %s.{pem,key,p12}",
+		client.Cert.Subject.String(), client.Cert.NotAfter.Local().Format(time.DateOnly), base)
+
+	return 0
+}
+
+// clientFileName turns a client name into a file name.
+func clientFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func newCAFlagSet(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet("anywhere ca "+name, pflag.ContinueOnError)
+	fs.Usage = printCAHelp
+	return fs
+}
+
+// parseCAFlags parses the flags of a subcommand, reporting whether it
+// succeeded.
+func parseCAFlags(fs *pflag.FlagSet, args []string) bool {
+	if err := fs.Parse(args); err != nil {
+		if !errors.Is(err, pflag.ErrHelp) {
+			log.Error().Str("scope", "ca").Err(err).Msg("Invalid arguments")
+		}
+		return false
+	}
+	return true
+}
@@ -17,6 +17,11 @@ import (
 var version string
 
 func main() {
+	// --- Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "ca" {
+		os.Exit(runCA(os.Args[2:]))
+	}
+
 	cfg := config.Parse()
 
 	if cfg.Help {
@@ -29,13 +34,17 @@ func main() {
 		os.Exit(0)
 	}
 
-	if cfg.UninstallCA {
-		err := core.UninstallCA()
+	if cfg.InstallCA {
+		results, err := core.InstallCA(core.CAOptions{Constrained: cfg.ConstrainCA, Domains: cfg.CADomains}, cfg.User)
 		if err != nil {
-			log.Error().Err(err).Msg("Uninstall root CA failed")
+			log.Error().Err(err).Msg("Install root CA failed")
 			os.Exit(1)
 		}
-		os.Exit(0)
+		os.Exit(printTrustResults(results, "installed"))
+	}
+
+	if cfg.UninstallCA {
+		os.Exit(printTrustResults(core.UninstallCA(cfg.User), "removed"))
 	}
 
 	// --- Resolve ip addresses
@@ -49,8 +58,16 @@ func main() {
 	hlog.SetLevel(hlog.LevelWarn)
 
 	// --- Build server host ports
-	h := core.Server(cfg)
+	h, err := core.Server(cfg)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot prepare http server")
+		os.Exit(1)
+	}
 	hs, err := core.ServerTLS(cfg, allIPs)
+	if err != nil && cfg.MTLS != "" {
+		log.Error().Err(err).Msg("Cannot prepare tls server for client certificate authentication")
+		os.Exit(1)
+	}
 	if err != nil {
 		log.Warn().Err(err).Msg("An issue occurred when preparing tls server, skipped")
 	}
@@ -135,6 +152,31 @@ func printStartup(cfg *config.Config, allIPs []string, tlsStarted bool) {
 	t.Render()
 }
 
+// printTrustResults prints the outcome per trust store, and returns the
+// exit code: 1 if any store failed.
+func printTrustResults(results []core.TrustResult, done string) int {
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Store", "Location", "Result"})
+
+	code := 0
+	for _, result := range results {
+		status := done
+		switch {
+		case result.Err != nil:
+			status = "failed: " + result.Err.Error()
+			code = 1
+		case result.Skipped != "":
+			status = "skipped: " + result.Skipped
+		}
+		t.AppendRow(table.Row{result.Store, result.Location, status})
+	}
+	t.Render()
+
+	return code
+}
+
 func openBrowser(cfg *config.Config, allIPs []string) {
 	if cfg.Silent {
 		return
@@ -1,10 +1,16 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/pflag"
@@ -13,35 +19,195 @@ import (
 )
 
 type Config struct {
-	Host        string // server host ip or hostname
-	Port        int    // server port
-	Dir         string // the root directory for static files
-	Silent      bool   // won't open browser automatically if enabled
-	EnableLog   bool   // print access log
-	Fallback    string // enable history fallback
-	Proxy       string // proxy URL
-	Help        bool   // print help information
-	Version     bool   // print version
-	InstallCA   bool   // install root CA certificate
-	UninstallCA bool   // uninstall root CA certificate
+	Host              string         `yaml:"host" toml:"host"`                               // server host ip or hostname
+	Port              int            `yaml:"port" toml:"port"`                               // server port
+	Dir               string         `yaml:"dir" toml:"dir"`                                 // the root directory for static files
+	Silent            bool           `yaml:"silent" toml:"silent"`                           // won't open browser automatically if enabled
+	EnableLog         bool           `yaml:"enable-log" toml:"enable-log"`                   // print access log
+	Watch             bool           `yaml:"watch" toml:"watch"`                             // live reload browsers on file changes
+	Upload            bool           `yaml:"upload" toml:"upload"`                           // accept file uploads
+	UploadLimit       int            `yaml:"upload-limit" toml:"upload-limit"`               // max upload request size in MB
+	WebDAV            bool           `yaml:"webdav" toml:"webdav"`                           // share the root directory over WebDAV
+	WebDAVWrite       bool           `yaml:"webdav-write" toml:"webdav-write"`               // allow modifications over WebDAV
+	WebDAVPath        string         `yaml:"webdav-prefix" toml:"webdav-prefix"`             // URL prefix of the WebDAV share
+	Compress          bool           `yaml:"compress" toml:"compress"`                       // compress responses on the fly
+	CompressMin       int            `yaml:"compress-min" toml:"compress-min"`               // min response size in bytes to compress
+	CompressTypes     []string       `yaml:"compress-types" toml:"compress-types"`           // MIME type patterns to compress
+	CompressCache     int            `yaml:"compress-cache" toml:"compress-cache"`           // compressed files cache size in MB
+	CleanURLs         bool           `yaml:"clean-urls" toml:"clean-urls"`                   // serve /about from about.html
+	CleanURLsRedirect bool           `yaml:"clean-urls-redirect" toml:"clean-urls-redirect"` // redirect /about.html to /about
+	IndexFiles        []string       `yaml:"index" toml:"index"`                             // directory index files, in order
+	TrailingSlash     string         `yaml:"trailing-slash" toml:"trailing-slash"`           // trailing slash policy of directories
+	ErrorPage         []string       `yaml:"error-page" toml:"error-page"`                   // error pages as `status=file`
+	ErrorPages        map[int]string `yaml:"-" toml:"-"`                                     // parsed error pages by status code
+	Theme             string         `yaml:"theme" toml:"theme"`                             // built-in directory listing theme
+	ListingTemplate   string         `yaml:"listing-template" toml:"listing-template"`       // custom directory listing template
+	Fallback          string         `yaml:"fallback" toml:"fallback"`                       // enable history fallback
+	FallbackPrefix    []string       `yaml:"fallback-prefix" toml:"fallback-prefix"`         // per-prefix fallbacks as `prefix=file`
+	Fallbacks         []FallbackRule `yaml:"fallbacks" toml:"fallbacks"`                     // per-prefix history fallbacks
+	Rewrites          []RewriteRule  `yaml:"rewrites" toml:"rewrites"`                       // history fallback rewrite rules
+	Rewrite           []string       `yaml:"rewrite" toml:"rewrite"`                         // rewrite rules as `from=to`
+	DisableDotRule    bool           `yaml:"disable-dot-rule" toml:"disable-dot-rule"`       // rewrite paths with dots as well
+	HTMLAccept        []string       `yaml:"html-accept" toml:"html-accept"`                 // Accept values that qualify as HTML
+	Proxy             []string       `yaml:"proxy" toml:"proxy"`                             // proxy rules as `[prefix=]url`
+	ProxyStrip        []string       `yaml:"proxy-strip" toml:"proxy-strip"`                 // proxy rules as `prefix=url`, prefix stripped
+	Proxies           []ProxyRule    `yaml:"proxies" toml:"proxies"`                         // path-prefixed proxy rules
+	Cache             []string       `yaml:"cache" toml:"cache"`                             // cache rules as `glob=cache-control`
+	CacheRules        []CacheRule    `yaml:"-" toml:"-"`                                     // parsed cache rules
+	SANs              []string       `yaml:"san" toml:"san"`                                 // extra names of the generated certificate
+	ConstrainCA       bool           `yaml:"constrain-ca" toml:"constrain-ca"`               // create the root CA with name constraints
+	CADomains         []string       `yaml:"ca-domain" toml:"ca-domain"`                     // extra domains permitted by the constrained CA
+	Cert              string         `yaml:"cert" toml:"cert"`                               // TLS certificate (chain) PEM file
+	Key               string         `yaml:"key" toml:"key"`                                 // TLS private key PEM file
+	ClientCA          string         `yaml:"client-ca" toml:"client-ca"`                     // CA PEM file of accepted client certificates
+	MTLS              string         `yaml:"mtls" toml:"mtls"`                               // client certificate mode: require or verify
+	Config            string         `yaml:"-" toml:"-"`                                     // config file path
+	Help              bool           `yaml:"-" toml:"-"`                                     // print help information
+	Version           bool           `yaml:"-" toml:"-"`                                     // print version
+	InstallCA         bool           `yaml:"-" toml:"-"`                                     // install root CA certificate
+	UninstallCA       bool           `yaml:"-" toml:"-"`                                     // uninstall root CA certificate
+	User              bool           `yaml:"-" toml:"-"`                                     // (un)install root CA for the current user only
+}
+
+// CaptureRefPattern matches `$1` and `${1}` capture group references of
+// rewrite targets.
+var CaptureRefPattern = regexp.MustCompile(`\$(\d+)|\$\{(\d+)\}`)
+
+// sanPattern matches DNS names, optionally with a leading wildcard label.
+var sanPattern = regexp.MustCompile(`^(\*\.)?([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// CADomain returns domain lower-cased, and whether it is a valid domain for
+// the name constraints of the CA: a DNS name, optionally with a leading dot,
+// but no wildcard.
+func CADomain(domain string) (string, bool) {
+	domain = strings.ToLower(domain)
+	if strings.HasPrefix(domain, "*") || !sanPattern.MatchString(strings.TrimPrefix(domain, ".")) {
+		return "", false
+	}
+	return domain, true
+}
+
+// Trailing slash policies
+const (
+	TrailingSlashAdd    = "add"    // redirect /dir to /dir/
+	TrailingSlashStrip  = "strip"  // redirect /dir/ to /dir
+	TrailingSlashIgnore = "ignore" // serve both
+)
+
+// Client certificate (mutual TLS) modes
+const (
+	MTLSRequire = "require" // reject clients without a valid certificate
+	MTLSVerify  = "verify"  // verify a certificate if the client sends one
+)
+
+// Themes are the built-in directory listing themes.
+var Themes = []string{"default", "minimal", "dark", "grid"}
+
+// RewriteRule rewrites history fallback requests whose path matches the From
+// regular expression to the To path.
+type RewriteRule struct {
+	From string `yaml:"from" toml:"from"`
+	To   string `yaml:"to" toml:"to"`
+}
+
+// FallbackRule serves the Index file for client-side routes under Prefix,
+// for several single page apps in one tree.
+type FallbackRule struct {
+	Prefix string `yaml:"prefix" toml:"prefix"`
+	Index  string `yaml:"index" toml:"index"`
+}
+
+// ProxyRule forwards requests whose path starts with Prefix to Target. With
+// StripPrefix the matched prefix is removed before joining the request path
+// to the target path.
+type ProxyRule struct {
+	Prefix      string `yaml:"prefix" toml:"prefix"`
+	Target      string `yaml:"target" toml:"target"`
+	StripPrefix bool   `yaml:"strip-prefix" toml:"strip-prefix"`
+}
+
+// CacheRule sets the Cache-Control header of files matching the Pattern glob.
+type CacheRule struct {
+	Pattern string
+	Value   string
+}
+
+// cachePresets are shorthands for common Cache-Control values.
+var cachePresets = map[string]string{
+	"immutable": "public, max-age=31536000, immutable",
+	"no-cache":  "no-cache",
+	"no-store":  "no-store",
 }
 
 func (cfg *Config) PortTLS() int { return cfg.Port + 1 }
 
+// Parse resolves the configuration from, in order of increasing precedence:
+// built-in defaults, the config file, ANYWHERE_* environment variables and
+// command line flags.
 func Parse() *Config {
-	cfg := &Config{}
-
-	pflag.StringVarP(&cfg.Host, "host", "h", "0.0.0.0", "server hostname")
-	pflag.IntVarP(&cfg.Port, "port", "p", 8000, "server port")
-	pflag.StringVarP(&cfg.Dir, "dir", "d", "./", "static file root directory")
-	pflag.BoolVarP(&cfg.Silent, "silent", "s", false, "don't open browser automatically")
-	pflag.BoolVarP(&cfg.EnableLog, "enable-log", "l", false, "print access log")
-	pflag.StringVarP(&cfg.Fallback, "fallback", "f", "", "enable html5 history mode (eg: /index.html)")
-	pflag.StringVar(&cfg.Proxy, "proxy", "", "proxy url (eg: http://localhost:7000/api)")
+	cfg := &Config{
+		Host: "0.0.0.0",
+		Port: 8000,
+		Dir:  "./",
+
+		UploadLimit: 1024,
+		WebDAVPath:  "/webdav",
+		CompressMin: 1024,
+
+		IndexFiles:    []string{"index.html"},
+		TrailingSlash: TrailingSlashAdd,
+	}
+
+	// The config file and environment are applied before the flags are
+	// defined, so that the merged values become the flag defaults and only
+	// flags given explicitly override them.
+	cfg.loadFile(scanConfigPath())
+	cfg.loadEnv()
+
+	pflag.StringVar(&cfg.Config, "config", cfg.Config, "config file (default: anywhere.yaml/anywhere.toml in the root directory)")
+	pflag.StringVarP(&cfg.Host, "host", "h", cfg.Host, "server hostname")
+	pflag.IntVarP(&cfg.Port, "port", "p", cfg.Port, "server port")
+	pflag.StringVarP(&cfg.Dir, "dir", "d", cfg.Dir, "static file root directory")
+	pflag.BoolVarP(&cfg.Silent, "silent", "s", cfg.Silent, "don't open browser automatically")
+	pflag.BoolVarP(&cfg.EnableLog, "enable-log", "l", cfg.EnableLog, "print access log")
+	pflag.BoolVar(&cfg.Watch, "watch", cfg.Watch, "live reload browsers when files change")
+	pflag.BoolVar(&cfg.Watch, "live-reload", cfg.Watch, "alias of --watch")
+	pflag.BoolVar(&cfg.Upload, "upload", cfg.Upload, "accept file uploads")
+	pflag.IntVar(&cfg.UploadLimit, "upload-limit", cfg.UploadLimit, "max upload size in MB")
+	pflag.BoolVar(&cfg.WebDAV, "webdav", cfg.WebDAV, "share the root directory over WebDAV")
+	pflag.BoolVar(&cfg.WebDAVWrite, "webdav-write", cfg.WebDAVWrite, "allow modifications over WebDAV")
+	pflag.StringVar(&cfg.WebDAVPath, "webdav-prefix", cfg.WebDAVPath, "URL prefix of the WebDAV share")
+	pflag.BoolVar(&cfg.Compress, "compress", cfg.Compress, "compress text responses with br, zstd or gzip")
+	pflag.IntVar(&cfg.CompressMin, "compress-min", cfg.CompressMin, "min response size in bytes to compress")
+	pflag.StringSliceVar(&cfg.CompressTypes, "compress-types", cfg.CompressTypes, "MIME types to compress, comma-separated (eg: 'text/*,application/json')")
+	pflag.IntVar(&cfg.CompressCache, "compress-cache", cfg.CompressCache, "cache compressed files in memory, size in MB")
+	pflag.BoolVar(&cfg.CleanURLs, "clean-urls", cfg.CleanURLs, "serve /about from about.html")
+	pflag.BoolVar(&cfg.CleanURLsRedirect, "clean-urls-redirect", cfg.CleanURLsRedirect, "redirect /about.html to /about, implies --clean-urls")
+	pflag.StringSliceVar(&cfg.IndexFiles, "index", cfg.IndexFiles, "directory index files, comma-separated, in order")
+	pflag.StringVar(&cfg.TrailingSlash, "trailing-slash", cfg.TrailingSlash, "trailing slash policy of directories (add, strip, ignore)")
+	pflag.StringArrayVar(&cfg.ErrorPage, "error-page", cfg.ErrorPage, "custom error page, repeatable (eg: 404=./errors/404.html)")
+	pflag.StringVar(&cfg.Theme, "theme", cfg.Theme, "directory listing theme (default, minimal, dark, grid)")
+	pflag.StringVar(&cfg.ListingTemplate, "listing-template", cfg.ListingTemplate, "custom directory listing template file")
+	pflag.StringVarP(&cfg.Fallback, "fallback", "f", cfg.Fallback, "enable html5 history mode (eg: /index.html)")
+	pflag.StringArrayVar(&cfg.FallbackPrefix, "fallback-prefix", cfg.FallbackPrefix, "fallback file of a path prefix, repeatable (eg: /admin=/admin/index.html)")
+	pflag.StringArrayVar(&cfg.Rewrite, "rewrite", cfg.Rewrite, "history fallback rewrite rule, repeatable (eg: '^/docs/(.*)$=/docs/$1.html')")
+	pflag.BoolVar(&cfg.DisableDotRule, "disable-dot-rule", cfg.DisableDotRule, "rewrite paths with dots to the fallback as well")
+	pflag.StringSliceVar(&cfg.HTMLAccept, "html-accept", cfg.HTMLAccept, "Accept values that qualify for the fallback (default: text/html,*/*)")
+	pflag.StringArrayVar(&cfg.Proxy, "proxy", cfg.Proxy, "proxy rule, repeatable (eg: /api=http://localhost:7000)")
+	pflag.StringArrayVar(&cfg.ProxyStrip, "proxy-strip", cfg.ProxyStrip, "proxy rule stripping the prefix, repeatable (eg: /auth=http://localhost:9000/v2)")
+	pflag.StringArrayVar(&cfg.Cache, "cache", cfg.Cache, "cache rule, repeatable, first match wins (eg: '*.html=no-cache')")
+	pflag.StringArrayVar(&cfg.SANs, "san", cfg.SANs, "extra DNS name or IP of the generated certificate, repeatable (eg: myapp.test, *.local.dev)")
+	pflag.BoolVar(&cfg.ConstrainCA, "constrain-ca", cfg.ConstrainCA, "create the root CA with name constraints (private IPs, localhost, .test, .local)")
+	pflag.StringArrayVar(&cfg.CADomains, "ca-domain", cfg.CADomains, "extra domain permitted by --constrain-ca, repeatable")
+	pflag.StringVar(&cfg.Cert, "cert", cfg.Cert, "TLS certificate PEM file, may hold the full chain")
+	pflag.StringVar(&cfg.Key, "key", cfg.Key, "TLS private key PEM file")
+	pflag.StringVar(&cfg.ClientCA, "client-ca", cfg.ClientCA, "CA PEM file of accepted client certificates (default: the local root CA)")
+	pflag.StringVar(&cfg.MTLS, "mtls", cfg.MTLS, "client certificate mode: require, verify")
 	pflag.BoolVar(&cfg.Help, "help", false, "print help information")
 	pflag.BoolVarP(&cfg.Version, "version", "v", false, "print version")
 	pflag.BoolVar(&cfg.InstallCA, "install-ca", false, "install root CA certificate")
 	pflag.BoolVar(&cfg.UninstallCA, "uninstall-ca", false, "uninstall root CA certificate")
+	pflag.BoolVar(&cfg.User, "user", false, "with --install-ca or --uninstall-ca, only use the trust stores of the current user, no sudo")
 
 	pflag.Usage = PrintHelp
 
@@ -71,9 +237,241 @@ func Parse() *Config {
 		os.Exit(1)
 	}
 
+	// Verify upload limit
+	if cfg.UploadLimit < 1 {
+		log.Error().Str("scope", "config").Msgf("invalid upload limit %d MB", cfg.UploadLimit)
+		os.Exit(1)
+	}
+
+	// Verify WebDAV prefix
+	cfg.WebDAVPath = "/" + strings.Trim(cfg.WebDAVPath, "/")
+	if cfg.WebDAV && cfg.WebDAVPath == "/" {
+		log.Error().Str("scope", "config").Msg("WebDAV prefix cannot be the root path")
+		os.Exit(1)
+	}
+
+	// Verify clean URLs and index files
+	if cfg.CleanURLsRedirect {
+		cfg.CleanURLs = true
+	}
+	switch cfg.TrailingSlash {
+	case TrailingSlashAdd, TrailingSlashStrip, TrailingSlashIgnore:
+	default:
+		log.Error().Str("scope", "config").Msgf("invalid trailing slash policy %q (allowed: add, strip, ignore)", cfg.TrailingSlash)
+		os.Exit(1)
+	}
+	for _, index := range cfg.IndexFiles {
+		if index == "" || strings.ContainsAny(index, `/\`) {
+			log.Error().Str("scope", "config").Msgf("invalid index file %q (must be a file name)", index)
+			os.Exit(1)
+		}
+	}
+
+	// Collect error pages
+	cfg.ErrorPages = make(map[int]string, len(cfg.ErrorPage))
+	for _, rule := range cfg.ErrorPage {
+		code, page, ok := strings.Cut(rule, "=")
+		status, err := strconv.Atoi(strings.TrimSpace(code))
+		if !ok || err != nil || status < 400 || status > 599 || strings.TrimSpace(page) == "" {
+			log.Error().Str("scope", "config").Msgf("invalid error page %q (expected status=file, e.g. 404=./404.html)", rule)
+			os.Exit(1)
+		}
+		page = expandPath(strings.TrimSpace(page))
+		if abs, err := filepath.Abs(page); err == nil {
+			page = abs
+		}
+		cfg.ErrorPages[status] = page
+	}
+
+	// Verify compression options
+	if cfg.CompressMin < 0 || cfg.CompressCache < 0 {
+		log.Error().Str("scope", "config").Msg("compression size options cannot be negative")
+		os.Exit(1)
+	}
+	for _, pattern := range cfg.CompressTypes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Error().Str("scope", "config").Err(err).Msgf("invalid compress type %q", pattern)
+			os.Exit(1)
+		}
+	}
+
+	// Verify listing theme and template
+	if cfg.Theme != "" && !slices.Contains(Themes, cfg.Theme) {
+		log.Error().Str("scope", "config").Msgf("unknown theme %q (available: %s)", cfg.Theme, strings.Join(Themes, ", "))
+		os.Exit(1)
+	}
+	if cfg.ListingTemplate != "" {
+		cfg.ListingTemplate = expandPath(cfg.ListingTemplate)
+		if abs, err := filepath.Abs(cfg.ListingTemplate); err == nil {
+			cfg.ListingTemplate = abs
+		}
+	}
+
+	// Collect and verify per-prefix fallbacks
+	for _, rule := range cfg.FallbackPrefix {
+		prefix, index, ok := strings.Cut(rule, "=")
+		if !ok {
+			log.Error().Str("scope", "config").Msgf("invalid fallback prefix %q (expected prefix=file)", rule)
+			os.Exit(1)
+		}
+		cfg.Fallbacks = append(cfg.Fallbacks, FallbackRule{Prefix: prefix, Index: index})
+	}
+	for _, rule := range cfg.Fallbacks {
+		if !strings.HasPrefix(rule.Prefix, "/") || !strings.HasPrefix(rule.Index, "/") {
+			log.Error().Str("scope", "config").Msgf("invalid fallback %s=%s (prefix and file must start with '/')", rule.Prefix, rule.Index)
+			os.Exit(1)
+		}
+	}
+
+	// Collect and verify rewrite rules
+	for _, rule := range cfg.Rewrite {
+		from, to, ok := strings.Cut(rule, "=")
+		if !ok {
+			log.Error().Str("scope", "config").Msgf("invalid rewrite rule %q (expected from=to)", rule)
+			os.Exit(1)
+		}
+		cfg.Rewrites = append(cfg.Rewrites, RewriteRule{From: from, To: to})
+	}
+	if len(cfg.Rewrites) > 0 && cfg.Fallback == "" && len(cfg.Fallbacks) == 0 {
+		log.Error().Str("scope", "config").Msg("rewrite rules need a history fallback (--fallback or --fallback-prefix)")
+		os.Exit(1)
+	}
+	for _, rule := range cfg.Rewrites {
+		re, err := regexp.Compile(rule.From)
+		if err != nil {
+			log.Error().Str("scope", "config").Err(err).Msgf("invalid rewrite rule from %q", rule.From)
+			os.Exit(1)
+		}
+		if !strings.HasPrefix(rule.To, "/") {
+			log.Error().Str("scope", "config").Msgf("invalid rewrite rule to %q (must start with '/')", rule.To)
+			os.Exit(1)
+		}
+		for _, ref := range CaptureRefPattern.FindAllStringSubmatch(rule.To, -1) {
+			if n, _ := strconv.Atoi(ref[1] + ref[2]); n > re.NumSubexp() {
+				log.Error().Str("scope", "config").Msgf("invalid rewrite rule to %q (%s refers to a missing capture group of %q)", rule.To, ref[0], rule.From)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Collect and verify proxy rules
+	for _, rule := range cfg.Proxy {
+		cfg.Proxies = append(cfg.Proxies, parseProxyRule(rule, false))
+	}
+	for _, rule := range cfg.ProxyStrip {
+		cfg.Proxies = append(cfg.Proxies, parseProxyRule(rule, true))
+	}
+	for _, rule := range cfg.Proxies {
+		if !strings.HasPrefix(rule.Prefix, "/") {
+			log.Error().Str("scope", "config").Msgf("invalid proxy prefix %q (must start with '/')", rule.Prefix)
+			os.Exit(1)
+		}
+		if !strings.HasPrefix(rule.Target, "http://") && !strings.HasPrefix(rule.Target, "https://") {
+			log.Error().Str("scope", "config").Msgf("invalid proxy target %q (must be a http or https url)", rule.Target)
+			os.Exit(1)
+		}
+	}
+
+	// Verify certificate names
+	for _, san := range cfg.SANs {
+		if net.ParseIP(san) == nil && !sanPattern.MatchString(strings.ToLower(san)) {
+			log.Error().Str("scope", "config").Msgf("invalid SAN %q (expected a DNS name, a wildcard like *.example.test or an IP)", san)
+			os.Exit(1)
+		}
+	}
+
+	for i, domain := range cfg.CADomains {
+		normalized, ok := CADomain(domain)
+		if !ok {
+			log.Error().Str("scope", "config").Msgf("invalid CA domain %q (expected a DNS name like example.dev)", domain)
+			os.Exit(1)
+		}
+		cfg.CADomains[i] = normalized
+	}
+	if len(cfg.CADomains) > 0 && !cfg.ConstrainCA {
+		log.Error().Str("scope", "config").Msg("--ca-domain requires --constrain-ca")
+		os.Exit(1)
+	}
+
+	// Verify certificate files
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		log.Error().Str("scope", "config").Msg("--cert and --key must be given together")
+		os.Exit(1)
+	}
+	for _, file := range []*string{&cfg.Cert, &cfg.Key} {
+		if *file == "" {
+			continue
+		}
+		*file = expandPath(*file)
+		if abs, err := filepath.Abs(*file); err == nil {
+			*file = abs
+		}
+	}
+
+	// Verify client certificate authentication
+	if cfg.ClientCA != "" {
+		cfg.ClientCA = expandPath(cfg.ClientCA)
+		if abs, err := filepath.Abs(cfg.ClientCA); err == nil {
+			cfg.ClientCA = abs
+		}
+		if cfg.MTLS == "" {
+			cfg.MTLS = MTLSRequire
+		}
+	}
+	switch cfg.MTLS {
+	case "", MTLSRequire, MTLSVerify:
+	default:
+		log.Error().Str("scope", "config").Msgf("invalid mtls mode %q (allowed: require, verify)", cfg.MTLS)
+		os.Exit(1)
+	}
+
+	// Collect and verify cache rules
+	for _, rule := range cfg.Cache {
+		cacheRule, err := parseCacheRule(rule)
+		if err != nil {
+			log.Error().Str("scope", "config").Err(err).Msgf("invalid cache rule %q", rule)
+			os.Exit(1)
+		}
+		cfg.CacheRules = append(cfg.CacheRules, cacheRule)
+	}
+
 	return cfg
 }
 
+// parseCacheRule parses a `glob=value` cache flag. The value is either a
+// Cache-Control header value, one of the cachePresets or a number of seconds
+// for a public max-age.
+func parseCacheRule(s string) (CacheRule, error) {
+	pattern, value, ok := strings.Cut(s, "=")
+	pattern, value = strings.TrimSpace(pattern), strings.TrimSpace(value)
+	if !ok || pattern == "" || value == "" {
+		return CacheRule{}, errors.New("expected glob=cache-control")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return CacheRule{}, err
+	}
+	if preset, ok := cachePresets[value]; ok {
+		value = preset
+	} else if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		value = fmt.Sprintf("public, max-age=%d", seconds)
+	}
+	return CacheRule{Pattern: pattern, Value: value}, nil
+}
+
+// parseProxyRule parses a `prefix=url` proxy flag. A bare url proxies every
+// request, like `/=url`.
+func parseProxyRule(s string, stripPrefix bool) ProxyRule {
+	prefix, target, ok := strings.Cut(s, "=")
+	if !ok || !strings.HasPrefix(prefix, "/") {
+		prefix, target = "/", s
+	}
+	return ProxyRule{
+		Prefix:      prefix,
+		Target:      target,
+		StripPrefix: stripPrefix,
+	}
+}
+
 // Resolve root directory
 func (cfg *Config) resolveRoot() {
 	if cfg.Dir == "" {
@@ -87,20 +485,7 @@ func (cfg *Config) resolveRoot() {
 
 	} else {
 
-		// expand ${HOME}
-		cfg.Dir = os.ExpandEnv(cfg.Dir)
-
-		// expand tilde
-		usr, err := user.Current()
-		if err != nil {
-			log.Error().Str("scope", "config").Err(err).Msg("cannot get current user")
-			os.Exit(1)
-		}
-		if cfg.Dir == "~" {
-			cfg.Dir = usr.HomeDir
-		} else if strings.HasPrefix(cfg.Dir, "~/") {
-			cfg.Dir = filepath.Join(usr.HomeDir, cfg.Dir[2:])
-		}
+		cfg.Dir = expandPath(cfg.Dir)
 
 		if absDir, err := filepath.Abs(cfg.Dir); err == nil {
 			cfg.Dir = absDir
@@ -116,24 +501,137 @@ func (cfg *Config) resolveRoot() {
 	}
 }
 
+// expandPath expands environment variables and a leading tilde in p.
+func expandPath(p string) string {
+	// expand ${HOME}
+	p = os.ExpandEnv(p)
+
+	// expand tilde
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	usr, err := user.Current()
+	if err != nil {
+		log.Error().Str("scope", "config").Err(err).Msg("cannot get current user")
+		os.Exit(1)
+	}
+	if p == "~" {
+		return usr.HomeDir
+	}
+	return filepath.Join(usr.HomeDir, p[2:])
+}
+
 func PrintHelp() {
 	fmt.Println(`anywhere - Run static file server anywhere
 
 Usage:
   anywhere [options] [port]
+  anywhere ca <info|export|rotate|path|verify|client>
+                          Manage the local root CA, see: anywhere ca help
+  anywhere ca client [-o <dir>] [--days <days>] [--password <pass>] <name>
+                          Issue a client certificate for --mtls, written to
+                          dir (default: current directory), valid for days
+                          (default: 365), the p12 file protected by pass
 
 Options:
+  --config <file>         Config file (default: anywhere.yaml, anywhere.yml or
+                          anywhere.toml in the root directory)
   -h, --host <hostname>   Hostname to bind (default: 0.0.0.0)
   -p, --port <port>       Port number (default: 8000)
   -d, --dir <dir>         Root directory (default: current directory)
   -s, --silent            Silent mode, don't open browser
   -l, --enable-log        Enable access logging
+  --watch, --live-reload  Reload pages in the browser when files change, CSS
+                          changes are applied without a full reload
+  --upload                Accept file uploads, multipart POST to a directory
+                          or PUT of a raw file body
+  --upload-limit <MB>     Max upload size in MB (default: 1024)
+  --webdav                Share the root directory over WebDAV (read-only)
+  --webdav-write          Allow modifications over WebDAV
+  --webdav-prefix <path>  URL prefix of the WebDAV share (default: /webdav)
+  --clean-urls            Serve /about from about.html
+  --clean-urls-redirect   Also redirect /about.html to /about and
+                          /docs/index.html to /docs/
+  --index <files>         Comma-separated directory index files, the first
+                          existing one is served (default: index.html)
+  --trailing-slash <policy>
+                          Trailing slash of directory URLs: add (default)
+                          redirects /docs to /docs/, strip redirects /docs/ to
+                          /docs, ignore serves both
+  --error-page <status=file>
+                          Custom error page for a status code, repeatable,
+                          <status>.html in the root directory is used when
+                          present (eg: --error-page 404=./errors/404.html)
+  --compress              Compress text responses on the fly with br, zstd or
+                          gzip, including listings and proxied responses
+  --compress-min <bytes>  Min response size to compress (default: 1024)
+  --compress-types <types>
+                          Comma-separated MIME types to compress, * wildcards
+                          allowed (default: text/*, JSON, JavaScript, XML, SVG)
+  --compress-cache <MB>   Cache compressed files in memory (default: 0, off)
+  --theme <name>          Directory listing theme: default, minimal, dark or
+                          grid (thumbnails for images)
+  --listing-template <file>
+                          Custom directory listing template (html/template),
+                          .anywhere/listing.gohtml in the root directory is
+                          used when present
   -f, --fallback <file>   Enable HTML5 history fallback (e.g. -f /index.html)
-  --proxy <url>           Proxy URL (eg: http://localhost:9090/api)
+  --fallback-prefix <prefix=file>
+                          History fallback file for a path prefix, repeatable,
+                          the longest prefix wins over --fallback
+                          (eg: --fallback-prefix /admin=/admin/index.html)
+  --rewrite <from=to>     History fallback rewrite rule, repeatable, from is a
+                          regular expression and to may refer to its groups,
+                          requires --fallback or --fallback-prefix
+                          (eg: --rewrite '^/docs/(.*)$=/docs/$1.html')
+  --disable-dot-rule      Rewrite paths with a dot in the last segment, too
+  --html-accept <values>  Comma-separated Accept values that qualify for the
+                          fallback (default: text/html,*/*)
+  --proxy [prefix=]<url>  Proxy requests under prefix to url, repeatable, the
+                          longest prefix wins (eg: --proxy /api=http://localhost:7000)
+  --proxy-strip <prefix=url>
+                          Same as --proxy, but strip the prefix before
+                          forwarding (eg: /auth=http://localhost:9000/v2)
+  --cache <glob=value>    Cache-Control for files matching glob, repeatable,
+                          the first match wins. A glob without a slash matches
+                          file names, "dir/**" everything below dir. The value
+                          is a header value, a number of seconds, or one of
+                          immutable, no-cache, no-store
+                          (eg: --cache '*.html=no-cache' --cache 'assets/**=immutable')
+  --san <name>            Extra DNS name or IP of the generated certificate,
+                          repeatable, wildcards allowed (eg: --san myapp.test
+                          --san '*.local.dev'). The hostname and
+                          <hostname>.local are included, other names get a
+                          certificate on demand
+  --constrain-ca          Create the root CA with X.509 name constraints, so it
+                          can only sign private IPs, localhost, .test, .local
+                          and --ca-domain names. Applies when the CA is
+                          created, see: anywhere ca rotate --constrain
+  --ca-domain <domain>    Extra domain permitted by --constrain-ca, with its
+                          subdomains, repeatable
+  --cert <file>           TLS certificate PEM file instead of the generated one,
+                          may hold the full chain, reloaded when it changes
+  --key <file>            TLS private key PEM file of --cert
+  --mtls <mode>           Authenticate HTTPS clients by certificate: require
+                          (HTTP redirects to HTTPS) or verify (if sent)
+  --client-ca <file>      CA PEM file of accepted client certificates, implies
+                          --mtls require (default: the local root CA, issue
+                          certificates with: anywhere ca client <name>)
   --help                  Show this help message
   -v, --version           Show version
-  --install-ca            Install root CA certificate (sudo required)
-  --uninstall-ca          Uninstall root CA certificate (sudo required)
+  --install-ca            Install root CA certificate into the system trust
+                          store, Firefox/Chromium NSS databases and the Java
+                          keystore (asks for sudo where needed)
+  --uninstall-ca          Uninstall root CA certificate from these stores
+  --user                  With --install-ca or --uninstall-ca, only use the
+                          stores of the current user, without sudo
+
+Configuration:
+  Options are merged in this order, later ones win:
+    config file < ANYWHERE_* environment variables < command line flags
+  Environment variables are named after the long option, e.g. ANYWHERE_PORT,
+  ANYWHERE_ENABLE_LOG. The config file additionally accepts "rewrites" and
+  "proxies" lists, see README.md.
 
 Examples:
   anywhere                    # Serve current dir on port 8000
@@ -141,5 +639,11 @@ Examples:
   anywhere -p 8989            # Same as above
   anywhere -d /home/www       # Serve /home/www
   anywhere -s -l              # Silent + access logging
-  anywhere -f /index.html     # SPA with HTML5 history fallback`)
+  anywhere --theme grid       # Browse a photo folder
+  anywhere -f /index.html     # SPA with HTML5 history fallback
+  anywhere --watch            # Live reload while editing
+  anywhere --upload           # Accept uploads, e.g. curl -T a.zip host:8000/a.zip
+  anywhere --config dev.yaml  # Load options from dev.yaml
+  anywhere --proxy /api=http://localhost:7000
+                              # Forward /api/* to a backend, serve the rest`)
 }
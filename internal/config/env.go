@@ -0,0 +1,70 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+const envPrefix = "ANYWHERE_"
+
+// loadEnv overrides cfg with ANYWHERE_* environment variables. The variable
+// name is derived from the yaml key of each field, e.g. `enable-log` is read
+// from ANYWHERE_ENABLE_LOG. Only scalar fields and string lists (comma
+// separated) can be set this way.
+func (cfg *Config) loadEnv() {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if err := setField(v.Field(i), value); err != nil {
+			log.Error().Str("scope", "config").Err(err).Msgf("invalid environment variable %s=%q", name, value)
+			os.Exit(1)
+		}
+	}
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return nil // structured lists are only configurable from file
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
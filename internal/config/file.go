@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// FileNames are the config files looked up in the root directory, in order,
+// when no config file is given explicitly.
+var FileNames = []string{"anywhere.yaml", "anywhere.yml", "anywhere.toml"}
+
+// scanConfigPath finds the config file before the real flag parsing happens.
+// It peeks at `--config` and `-d, --dir` from the command line, falls back to
+// ANYWHERE_CONFIG / ANYWHERE_DIR, and finally probes the root directory for
+// one of FileNames. An empty string means there is no config file.
+func scanConfigPath() string {
+	var (
+		configPath string
+		dir        string
+	)
+
+	fs := pflag.NewFlagSet("scan", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	fs.StringVar(&configPath, "config", "", "")
+	fs.StringVarP(&dir, "dir", "d", "", "")
+	fs.Bool("help", false, "")
+	_ = fs.Parse(os.Args[1:])
+
+	if configPath == "" {
+		configPath = os.Getenv(envPrefix + "CONFIG")
+	}
+	if configPath != "" {
+		return expandPath(configPath)
+	}
+
+	if dir == "" {
+		dir = os.Getenv(envPrefix + "DIR")
+	}
+	if dir == "" {
+		dir = "./"
+	}
+	dir = expandPath(dir)
+
+	for _, name := range FileNames {
+		p := filepath.Join(dir, name)
+		if stat, err := os.Stat(p); err == nil && !stat.IsDir() {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// loadFile merges the config file at path into cfg, only keys present in the
+// file are overwritten. Relative paths in the file (`dir`, `listing-template`,
+// `cert`, `key`, `client-ca` and the files of `error-page`) are resolved
+// against the directory containing the file.
+func (cfg *Config) loadFile(path string) {
+	if path == "" {
+		return
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Error().Str("scope", "config").Err(err).Msgf("cannot read config file %s", path)
+		os.Exit(1)
+	}
+
+	// Track whether the file sets `dir` at all
+	dirBefore := cfg.Dir
+	cfg.Dir = ""
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(content), cfg)
+		if err == nil {
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				err = errors.New("unknown key " + undecoded[0].String())
+			}
+		}
+	default:
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+		if errors.Is(err, io.EOF) { // empty file
+			err = nil
+		}
+	}
+	if err != nil {
+		log.Error().Str("scope", "config").Err(err).Msgf("invalid config file %s", path)
+		os.Exit(1)
+	}
+
+	base := filepath.Dir(path)
+	if cfg.Dir == "" {
+		cfg.Dir = dirBefore
+	} else {
+		cfg.Dir = resolveFrom(base, cfg.Dir)
+	}
+	cfg.ListingTemplate = resolveFrom(base, cfg.ListingTemplate)
+	cfg.Cert = resolveFrom(base, cfg.Cert)
+	cfg.Key = resolveFrom(base, cfg.Key)
+	cfg.ClientCA = resolveFrom(base, cfg.ClientCA)
+	for i, rule := range cfg.ErrorPage {
+		if status, page, ok := strings.Cut(rule, "="); ok {
+			cfg.ErrorPage[i] = status + "=" + resolveFrom(base, strings.TrimSpace(page))
+		}
+	}
+
+	cfg.Config = path
+}
+
+// resolveFrom expands p and resolves it against base if it is relative.
+// Empty paths are kept.
+func resolveFrom(base, p string) string {
+	if p == "" {
+		return p
+	}
+	p = expandPath(p)
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(base, p)
+	}
+	return p
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// CA export formats
+const (
+	ExportPEM    = "pem"
+	ExportDER    = "der"
+	ExportPKCS12 = "p12"
+)
+
+// CAInfo describes the local root CA.
+type CAInfo struct {
+	Subject     string
+	Serial      string
+	Fingerprint string // SHA-256, colon separated hex
+	NotBefore   time.Time
+	NotAfter    time.Time
+	Trusted     bool     // trusted by the system trust store
+	Constraints []string // permitted domains and IP ranges, if constrained
+	CertPath    string
+	KeyPath     string
+}
+
+// ErrNoCA is returned when the local root CA has not been created yet.
+var ErrNoCA = errors.New("no local root CA yet, run anywhere once to create it")
+
+// CAPaths returns the paths of the root CA certificate and private key.
+func CAPaths() (cert, key string) {
+	return caCertPath(), caKeyPath()
+}
+
+// LoadCAInfo describes the local root CA.
+func LoadCAInfo() (*CAInfo, error) {
+	cert, _, err := loadExistingCA()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CAInfo{
+		Subject:     cert.Subject.String(),
+		Serial:      fmt.Sprintf("%X", cert.SerialNumber),
+		Fingerprint: Fingerprint(cert),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		Trusted:     caTrusted(cert),
+		Constraints: nameConstraints(cert),
+		CertPath:    caCertPath(),
+		KeyPath:     caKeyPath(),
+	}, nil
+}
+
+// ExportCA encodes the root CA certificate in the format, along with its
+// private key if withKey is set. PKCS#12 files are protected by password.
+func ExportCA(format string, withKey bool, password string) ([]byte, error) {
+	cert, key, err := loadExistingCA()
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case ExportPEM:
+		out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		if withKey {
+			keyPEM, err := os.ReadFile(caKeyPath())
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, keyPEM...)
+		}
+		return out, nil
+
+	case ExportDER:
+		if withKey {
+			return nil, errors.New("DER holds the certificate only, use pem or p12 to export the key")
+		}
+		return cert.Raw, nil
+
+	case ExportPKCS12:
+		if withKey {
+			return pkcs12.Modern.Encode(key, cert, nil, password)
+		}
+		return pkcs12.Modern.EncodeTrustStore([]*x509.Certificate{cert}, password)
+
+	default:
+		return nil, fmt.Errorf("unknown export format %q (allowed: pem, der, p12)", format)
+	}
+}
+
+// RotateCA replaces the root CA with a new key and certificate, removing
+// the old one from the trust stores and installing the new one.
+// Certificates issued by the old CA are no longer trusted afterward.
+func RotateCA(opts CAOptions) (*CAInfo, error) {
+	if _, _, err := loadExistingCA(); err == nil {
+		if err = trustErr(untrustCA(false)); err != nil {
+			log.Warn().Str("scope", "cert-ca").Err(err).Msg("Cannot remove the old CA from the trust stores")
+		}
+	}
+
+	if _, _, err := createCA(opts); err != nil {
+		return nil, err
+	}
+	_ = os.RemoveAll(leafDir()) // issued by the old CA
+
+	if err := trustErr(trustCA(false)); err != nil {
+		log.Warn().Str("scope", "cert-ca").Err(err).Msgf("Cannot install the new CA into the trust stores, you can manually trust %s", caCertPath())
+	}
+
+	return LoadCAInfo()
+}
+
+// VerifyChain checks that the leaf certificate of chain, followed by its
+// intermediates, is issued by the local root CA and valid for host (if not
+// empty). It returns the verified chain.
+func VerifyChain(chain []*x509.Certificate, host string) ([]*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+
+	ca, _, err := loadExistingCA()
+	if err != nil {
+		return nil, err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	chains, err := chain[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chains[0], nil
+}
+
+// FetchChain returns the certificate chain served at addr (host:port),
+// without verifying it.
+func FetchChain(addr string) ([]*x509.Certificate, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true, // verified against the local CA by VerifyChain
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	return conn.ConnectionState().PeerCertificates, nil
+}
+
+// ReadChain reads the PEM certificates of a file, or a DER certificate.
+func ReadChain(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := data
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		if chain, err = x509.ParseCertificates(raw); err != nil || len(chain) == 0 {
+			return nil, fmt.Errorf("no PEM or DER certificate in %s", path)
+		}
+	}
+	return chain, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert, as colon separated
+// hex like openssl prints it.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// nameConstraints lists the permitted domains and IP ranges of the CA.
+func nameConstraints(ca *x509.Certificate) []string {
+	constraints := slices.Clone(ca.PermittedDNSDomains)
+	for _, ipNet := range ca.PermittedIPRanges {
+		constraints = append(constraints, ipNet.String())
+	}
+	return constraints
+}
+
+// loadExistingCA loads the root CA, returning ErrNoCA if it does not exist.
+func loadExistingCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, key, err := loadCA()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrNoCA
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load root CA at %s: %w", caDir(), err)
+	}
+	return cert, key, nil
+}
+
+// caTrusted reports whether the system trust store trusts cert.
+func caTrusted(cert *x509.Certificate) bool {
+	_, err := cert.Verify(x509.VerifyOptions{
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
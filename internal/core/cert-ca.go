@@ -9,10 +9,12 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/fs"
 	"math/big"
+	"net"
 	"os"
-	"os/exec"
-	"runtime"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/AyakuraYuki/go-anywhere/internal/log"
@@ -22,24 +24,66 @@ const (
 	caName = "go-anywhere Root CA"
 )
 
-func loadOrCreateCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
-	if cert, key, err := loadCA(); err == nil {
+// CAOptions configures the creation of the root CA.
+type CAOptions struct {
+	// Constrained limits the CA with X.509 name constraints to private IP
+	// ranges, localhost, .test, .local and Domains, so that a leaked key
+	// cannot be used against other sites.
+	Constrained bool
+
+	// Additional domains permitted by a constrained CA, with their subdomains
+	Domains []string
+}
+
+// Names permitted by a constrained CA, along with CAOptions.Domains
+var (
+	permittedDomains  = []string{"localhost", "test", "local"}
+	permittedIPRanges = []string{
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	}
+)
+
+func loadOrCreateCA(opts CAOptions) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, key, err := loadCA()
+	if err == nil {
+		if opts.Constrained && !constrained(cert) {
+			log.Warn().Str("scope", "cert-ca").Msg(`The local root CA has no name constraints, they only apply to a new CA.
+  Run: anywhere ca rotate --yes --constrain`)
+		}
 		return cert, key, nil
 	}
+	if !noCA() {
+		// an unusable CA is replaced, which breaks the trust of every device
+		// that trusted the old one, so say it loudly
+		log.Warn().Str("scope", "cert-ca").Msgf(`!!! Cannot load the local root CA: %v
+  !!! A NEW ROOT CA IS BEING GENERATED at %s
+  !!! Devices and browsers that trusted the old CA must trust the new one,
+  !!! export it with: anywhere ca export --out rootCA.pem
+`, err, caDir())
+	}
 
-	cert, key, err := createCA()
+	cert, key, err = createCA(opts)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if err = InstallCA(); err != nil {
+	// NSS and Java stores are left to an explicit --install-ca
+	if err = installSystem(false).Err; err != nil {
 		log.Warn().Str("scope", "cert-ca").Msgf(`Cannot auto-install CA into trust store: %v
   You can manually trust the CA cert at %s
-  Or run: sudo anywhere --install-ca (required run anywhere at least once)
+  Or run: anywhere --install-ca
 `, err, caCertPath())
 	} else {
-		log.Debug().Str("scope", "cert-ca").Msgf(`Local CA installed into system trust store.
-  Browsers will trust certificates from this server.
+		log.Debug().Str("scope", "cert-ca").Msgf(`Local CA installed into the system trust store.
+  Browsers will trust certificates from this server, run anywhere --install-ca
+  for Firefox and Java as well.
   CA cert location: %s
 `, caCertPath())
 	}
@@ -47,6 +91,18 @@ func loadOrCreateCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
 	return cert, key, nil
 }
 
+// noCA reports whether neither the certificate nor the key of the local
+// root CA exists, as on first use. Any other state that fails to load is a
+// broken CA.
+func noCA() bool {
+	for _, p := range []string{caCertPath(), caKeyPath()} {
+		if _, err := os.Lstat(p); !errors.Is(err, fs.ErrNotExist) {
+			return false
+		}
+	}
+	return true
+}
+
 func loadCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
 	certPEM, err := os.ReadFile(caCertPath())
 	if err != nil {
@@ -85,7 +141,7 @@ func loadCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
 	return cert, key, nil
 }
 
-func createCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+func createCA(opts CAOptions) (*x509.Certificate, *ecdsa.PrivateKey, error) {
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return nil, nil, err
@@ -109,6 +165,15 @@ func createCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
 		MaxPathLenZero:        true,
 	}
 
+	if opts.Constrained {
+		tmpl.PermittedDNSDomainsCritical = true
+		tmpl.PermittedDNSDomains = append(slices.Clone(permittedDomains), opts.Domains...)
+		for _, cidr := range permittedIPRanges {
+			_, ipNet, _ := net.ParseCIDR(cidr) // constant, valid
+			tmpl.PermittedIPRanges = append(tmpl.PermittedIPRanges, ipNet)
+		}
+	}
+
 	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, privKey.Public(), privKey)
 	if err != nil {
 		return nil, nil, err
@@ -141,74 +206,59 @@ func createCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
 	return caCert, privKey, nil
 }
 
-func InstallCA() error {
-	switch runtime.GOOS {
-	case "darwin":
-		return runCmd("sudo", "security", "add-trusted-cert",
-			"-d",
-			"-r", "trustRoot",
-			"-k", "/Library/Keychains/System.keychain",
-			caCertPath())
-
-	case "windows":
-		return runCmd("certutil", "-addstore", "-user", "Root", caCertPath())
-
-	case "linux":
-		// Debian/Ubuntu
-		if _, err := exec.LookPath("update-ca-certificates"); err == nil {
-			dst := "/usr/local/share/ca-certificates/go-anywhere-ca.crt"
-			if err = runCmd("sudo", "cp", caCertPath(), dst); err != nil {
-				return err
-			}
-			return runCmd("sudo", "update-ca-certificates", "--fresh")
-		}
+// constrained reports whether the CA has name constraints.
+func constrained(ca *x509.Certificate) bool {
+	return len(ca.PermittedDNSDomains) > 0 || len(ca.PermittedIPRanges) > 0
+}
 
-		// RHEL/Fedora/Arch
-		if _, err := exec.LookPath("trust"); err == nil {
-			return runCmd("sudo", "trust", "anchor", "--store", caCertPath())
+// checkNameConstraints returns an error naming the first DNS name or IP that
+// the name constraints of the CA do not permit.
+func checkNameConstraints(ca *x509.Certificate, names []string, ips []net.IP) error {
+	for _, name := range names {
+		if !dnsPermitted(ca, name) {
+			return fmt.Errorf("%q is outside the name constraints of the local root CA (permitted: %s), "+
+				"add it with --ca-domain and run: anywhere ca rotate --yes --constrain --domain <domain>",
+				name, strings.Join(ca.PermittedDNSDomains, ", "))
 		}
-
-		return fmt.Errorf("no supported trust store manager found (need update-ca-certificates or trust)")
-
-	default:
-		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
+	for _, ip := range ips {
+		if !ipPermitted(ca, ip) {
+			return fmt.Errorf("%s is outside the name constraints of the local root CA (permitted: private, loopback and link-local addresses)", ip)
+		}
+	}
+	return nil
 }
 
-func UninstallCA() error {
-	defer func(path string) { _ = os.RemoveAll(path) }(caDir())
-
-	switch runtime.GOOS {
-	case "darwin":
-		return runCmd("sudo", "security", "remove-trusted-cert", "-d", caCertPath())
-
-	case "windows":
-		return runCmd("certutil", "-delstore", "-user", "Root", caName)
-
-	case "linux":
-		// Debian/Ubuntu
-		if _, err := exec.LookPath("update-ca-certificates"); err == nil {
-			dst := "/usr/local/share/ca-certificates/go-anywhere-ca.crt"
-			if err = runCmd("sudo", "rm", "-f", dst); err != nil {
-				return err
+// dnsPermitted reports whether the CA may sign name, a domain constraint
+// permitting the domain and its subdomains, one with a leading dot only the
+// subdomains.
+func dnsPermitted(ca *x509.Certificate, name string) bool {
+	if len(ca.PermittedDNSDomains) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, domain := range ca.PermittedDNSDomains {
+		domain = strings.ToLower(domain)
+		if strings.HasPrefix(domain, ".") {
+			if strings.HasSuffix(name, domain) {
+				return true
 			}
-			return runCmd("sudo", "update-ca-certificates", "--fresh")
+		} else if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
 		}
-
-		// RHEL/Fedora/Arch
-		if _, err := exec.LookPath("trust"); err == nil {
-			return runCmd("sudo", "trust", "anchor", "--remove", caCertPath())
-		}
-
-		return fmt.Errorf("no supported trust store manager found (need update-ca-certificates or trust)")
 	}
-
-	return nil
+	return false
 }
 
-func runCmd(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// ipPermitted reports whether the CA may sign ip.
+func ipPermitted(ca *x509.Certificate, ip net.IP) bool {
+	if len(ca.PermittedIPRanges) == 0 {
+		return true
+	}
+	for _, ipNet := range ca.PermittedIPRanges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ClientCert is a client certificate issued by the local root CA.
+type ClientCert struct {
+	CertPEM []byte
+	KeyPEM  []byte
+	PKCS12  []byte // certificate, key and CA for browsers and phones
+	Cert    *x509.Certificate
+}
+
+// IssueClientCert signs a client certificate for mutual TLS with the local
+// root CA, named name and valid for days. The PKCS#12 bundle is protected by
+// password.
+func IssueClientCert(name string, days int, password string) (*ClientCert, error) {
+	if name == "" {
+		return nil, errors.New("client name is empty")
+	}
+	if days < 1 {
+		return nil, errors.New("validity must be at least one day")
+	}
+
+	caCert, caKey, err := loadExistingCA()
+	if err != nil {
+		return nil, err
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"go-anywhere client"},
+			CommonName:   name,
+		},
+		NotBefore:   time.Now(),
+		NotAfter:    time.Now().AddDate(0, 0, days),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, clientKey.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := pkcs12.Modern.Encode(clientKey, cert, []*x509.Certificate{caCert}, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientCert{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		PKCS12:  bundle,
+		Cert:    cert,
+	}, nil
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// certReloadInterval is how often certificate files are checked for changes.
+const certReloadInterval = 5 * time.Second
+
+// certFile is a certificate and key loaded from PEM files, the certificate
+// file may hold the full chain. The files are reloaded when they change on
+// disk, so rotated certificates are picked up without a restart.
+type certFile struct {
+	certPath string
+	keyPath  string
+
+	mu      sync.Mutex
+	checked time.Time
+	stamp   string
+	cert    *tls.Certificate
+}
+
+// loadCertFile loads the certificate and key files, failing if they are
+// unreadable or do not match.
+func loadCertFile(certPath, keyPath string) (*certFile, error) {
+	cf := &certFile{certPath: certPath, keyPath: keyPath}
+	if err := cf.reload(); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (cf *certFile) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	if time.Since(cf.checked) >= certReloadInterval {
+		if err := cf.reload(); err != nil {
+			// keep serving the previous certificate, e.g. while the files
+			// are being replaced one after the other
+			log.Warn().Str("scope", "cert").Err(err).Msg("cannot reload certificate, keep using the previous one")
+		}
+	}
+	return cf.cert, nil
+}
+
+// reload reads the files again if their size or modification time changed.
+func (cf *certFile) reload() error {
+	cf.checked = time.Now()
+
+	stamp := ""
+	for _, path := range []string{cf.certPath, cf.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		stamp += fmt.Sprintf("%d-%d;", info.Size(), info.ModTime().UnixNano())
+	}
+	if stamp == cf.stamp {
+		return nil
+	}
+	cf.stamp = stamp // report broken files once, not on every check
+
+	cert, err := tls.LoadX509KeyPair(cf.certPath, cf.keyPath)
+	if err != nil {
+		return err
+	}
+	if cf.cert != nil {
+		log.Info().Str("scope", "cert").Msgf("reloaded certificate %s", cf.certPath)
+	}
+	cf.cert = &cert
+	return nil
+}
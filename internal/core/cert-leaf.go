@@ -0,0 +1,134 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// leafRenewBefore is how long before its expiry a persisted server
+// certificate is replaced by a new one.
+const leafRenewBefore = 30 * 24 * time.Hour
+
+// leafMaxIdle is how long a persisted server certificate is kept without
+// being used, e.g. the one of a network the machine no longer joins.
+const leafMaxIdle = 90 * 24 * time.Hour
+
+// loadOrGenServerCert returns the persisted server certificate for the IPs
+// and names, or signs and persists a new one if there is none, it expires
+// soon, or it was not issued by the CA (e.g. after a rotation). Reusing the
+// certificate keeps clients that pinned it working across restarts.
+func loadOrGenServerCert(caCert *x509.Certificate, caKey *ecdsa.PrivateKey, ips, names []string) (crt, key []byte, err error) {
+	certPath, keyPath := leafPaths(ips, names)
+
+	if crt, key, err = loadLeaf(caCert, certPath, keyPath); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(certPath, now, now) // last used, see pruneLeafCerts
+		return crt, key, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Debug().Str("scope", "cert").Err(err).Msgf("Renewing server certificate %s", certPath)
+	}
+
+	crt, key, err = genServerCert(caCert, caKey, ips, names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pruneLeafCerts()
+	if err = saveLeaf(certPath, keyPath, crt, key); err != nil {
+		log.Warn().Str("scope", "cert").Err(err).Msg("Cannot persist server certificate, a new one is issued on every start")
+	}
+
+	return crt, key, nil
+}
+
+// leafPaths returns the certificate and key paths of the server certificate
+// for the IPs and names, keyed by the set of subject alternative names.
+func leafPaths(ips, names []string) (certPath, keyPath string) {
+	sans := make([]string, 0, len(ips)+len(names)+2)
+	for _, ip := range slices.Concat(ips, []string{"127.0.0.1", "::1"}) {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			sans = append(sans, "ip:"+parsed.String())
+		}
+	}
+	for _, name := range names {
+		sans = append(sans, "dns:"+name)
+	}
+	slices.Sort(sans)
+	sans = slices.Compact(sans)
+
+	sum := sha256.Sum256([]byte(strings.Join(sans, "\n")))
+	base := filepath.Join(leafDir(), hex.EncodeToString(sum[:8]))
+	return base + ".pem", base + ".key"
+}
+
+// loadLeaf loads a persisted server certificate, failing if it was not
+// issued by caCert or is about to expire.
+func loadLeaf(caCert *x509.Certificate, certPath, keyPath string) (crt, key []byte, err error) {
+	if crt, err = os.ReadFile(certPath); err != nil {
+		return nil, nil, err
+	}
+	if key, err = os.ReadFile(keyPath); err != nil {
+		return nil, nil, err
+	}
+
+	pair, err := tls.X509KeyPair(crt, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if time.Until(pair.Leaf.NotAfter) < leafRenewBefore {
+		return nil, nil, errors.New("certificate expires soon")
+	}
+	if pair.Leaf.Subject.CommonName != leafName {
+		return nil, nil, errors.New("certificate named like the CA")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	if _, err = pair.Leaf.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		return nil, nil, err
+	}
+
+	return crt, key, nil
+}
+
+func saveLeaf(certPath, keyPath string, crt, key []byte) error {
+	if err := os.MkdirAll(leafDir(), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, crt, 0644)
+}
+
+// pruneLeafCerts removes persisted server certificates that are unusable,
+// expire soon, or were not used for leafMaxIdle. The modification time of a
+// certificate file is the last time it was used.
+func pruneLeafCerts() {
+	certPaths, _ := filepath.Glob(filepath.Join(leafDir(), "*.pem"))
+	for _, certPath := range certPaths {
+		keyPath := strings.TrimSuffix(certPath, ".pem") + ".key"
+		pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+		info, statErr := os.Stat(certPath)
+		if err == nil && statErr == nil &&
+			time.Until(pair.Leaf.NotAfter) >= leafRenewBefore &&
+			time.Since(info.ModTime()) < leafMaxIdle {
+			continue
+		}
+		_ = os.Remove(certPath)
+		_ = os.Remove(keyPath)
+	}
+}
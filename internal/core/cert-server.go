@@ -4,35 +4,51 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"math/big"
 	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
 )
 
-// GenSelfSignedCert generates a TLS certificate trusted by browsers.
-//
-// Workflow:
-//  1. Check if a local root CA already exists
-//  2. If not, generate one and attempt to install it into the system trust
-//     store.
-//  3. Use the root CA to sign a server certificate for the given IPs and
-//     localhost.
-//
-// If CA installation fails, the cert is still generated and will work - just
-// won't be auto-trusted by browsers.
-func GenSelfSignedCert(ips []string) (crt, key []byte, err error) {
-	caCert, caKey, err := loadOrCreateCA()
-	if err != nil {
-		return nil, nil, err
+// serverNames returns the subject alternative names of the server
+// certificate: localhost, the machine hostname and its mDNS name
+// (<hostname>.local), and the extra names, which may be IP addresses. The
+// hostnames are left out if the name constraints of ca do not permit them.
+func serverNames(ca *x509.Certificate, extra []string) (names, ips []string) {
+	names = []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		// macOS reports the mDNS name as the hostname already
+		hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+		if base := strings.TrimSuffix(hostname, ".local"); base != "" {
+			hostname = base
+		}
+		for _, name := range []string{hostname, hostname + ".local"} {
+			if dnsPermitted(ca, name) && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
 	}
 
-	return genServerCert(caCert, caKey, ips)
+	for _, san := range extra {
+		if net.ParseIP(san) != nil {
+			ips = append(ips, san)
+		} else if name := strings.ToLower(san); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, ips
 }
 
-func genServerCert(caCert *x509.Certificate, caKey *ecdsa.PrivateKey, ips []string) (crt, key []byte, err error) {
+func genServerCert(caCert *x509.Certificate, caKey *ecdsa.PrivateKey, ips, names []string) (crt, key []byte, err error) {
 	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return nil, nil, err
@@ -42,16 +58,23 @@ func genServerCert(caCert *x509.Certificate, caKey *ecdsa.PrivateKey, ips []stri
 
 	tmpl := &x509.Certificate{
 		SerialNumber: serial,
+		// a subject of its own, OpenSSL takes a leaf named like its issuer
+		// for a self-signed certificate
 		Subject: pkix.Name{
 			Country:      []string{"CN"},
 			Organization: []string{"go-anywhere static file server"},
-			CommonName:   caName,
+			CommonName:   leafName,
 		},
 		NotBefore:   time.Now(),
 		NotAfter:    time.Now().AddDate(1, 0, 0),
 		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		DNSNames:    []string{"localhost"},
+	}
+
+	for _, name := range names {
+		if !slices.Contains(tmpl.DNSNames, name) {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
 	}
 
 	seen := make(map[string]bool)
@@ -65,6 +88,10 @@ func genServerCert(caCert *x509.Certificate, caKey *ecdsa.PrivateKey, ips []stri
 		}
 	}
 
+	if err = checkNameConstraints(caCert, tmpl.DNSNames, tmpl.IPAddresses); err != nil {
+		return nil, nil, err
+	}
+
 	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, serverKey.Public(), caKey)
 	if err != nil {
 		return nil, nil, err
@@ -81,3 +108,154 @@ func genServerCert(caCert *x509.Certificate, caKey *ecdsa.PrivateKey, ips []stri
 
 	return crt, key, nil
 }
+
+// leafName is the common name of server certificates.
+const leafName = "go-anywhere server"
+
+// maxIssuedCerts limits the certificates issued on demand kept in memory.
+const maxIssuedCerts = 256
+
+// certIssuer serves the server certificate, and for server names (SNI) it
+// does not cover, issues certificates signed by the local CA on demand.
+type certIssuer struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	cert   *tls.Certificate
+
+	mu      sync.Mutex
+	issued  map[string]*tls.Certificate
+	pending map[string]*issueCall
+}
+
+// issueCall is a certificate being issued, concurrent handshakes for the
+// same name wait for it instead of issuing one of their own.
+type issueCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+func newCertIssuer(caCert *x509.Certificate, caKey *ecdsa.PrivateKey, ips, names []string) (*certIssuer, error) {
+	crt, key, err := loadOrGenServerCert(caCert, caKey, ips, names)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(crt, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certIssuer{
+		caCert:  caCert,
+		caKey:   caKey,
+		cert:    &cert,
+		issued:  make(map[string]*tls.Certificate),
+		pending: make(map[string]*issueCall),
+	}, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. Certificates are
+// signed outside the lock, so handshakes for other names are not held up.
+func (ci *certIssuer) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" || !validHostname(name) || ci.cert.Leaf.VerifyHostname(name) == nil {
+		return ci.cert, nil
+	}
+
+	if !dnsPermitted(ci.caCert, name) {
+		log.Debug().Str("scope", "cert").Msgf("%s is outside the name constraints of the local root CA", name)
+		return ci.cert, nil
+	}
+
+	ci.mu.Lock()
+	if cert, ok := ci.issued[name]; ok && time.Now().Before(cert.Leaf.NotAfter) {
+		ci.mu.Unlock()
+		return cert, nil
+	}
+	if call, ok := ci.pending[name]; ok {
+		ci.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.cert, call.err
+		case <-hello.Context().Done():
+			return nil, hello.Context().Err()
+		}
+	}
+	call := &issueCall{done: make(chan struct{})}
+	ci.pending[name] = call
+	ci.mu.Unlock()
+
+	call.cert, call.err = ci.issue(name)
+
+	ci.mu.Lock()
+	delete(ci.pending, name)
+	if call.err == nil {
+		if _, ok := ci.issued[name]; !ok && len(ci.issued) >= maxIssuedCerts {
+			ci.evictOldest()
+		}
+		ci.issued[name] = call.cert
+	}
+	ci.mu.Unlock()
+	close(call.done)
+
+	return call.cert, call.err
+}
+
+// issue signs a certificate for name.
+func (ci *certIssuer) issue(name string) (*tls.Certificate, error) {
+	crt, key, err := genServerCert(ci.caCert, ci.caKey, nil, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(crt, key)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Str("scope", "cert").Msgf("issued certificate for %s", name)
+
+	return &cert, nil
+}
+
+// evictOldest removes the certificate issued first, ci.mu must be held.
+func (ci *certIssuer) evictOldest() {
+	var (
+		oldest    string
+		notBefore time.Time
+	)
+	for name, cert := range ci.issued {
+		if oldest == "" || cert.Leaf.NotBefore.Before(notBefore) {
+			oldest, notBefore = name, cert.Leaf.NotBefore
+		}
+	}
+	delete(ci.issued, oldest)
+}
+
+// validHostname reports whether name is a syntactically valid DNS name.
+func validHostname(name string) bool {
+	if len(name) > 253 || net.ParseIP(name) != nil {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// permittedIPs returns the IPs that the name constraints of ca permit.
+func permittedIPs(ca *x509.Certificate, ips []string) []string {
+	permitted := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil && ipPermitted(ca, parsed) {
+			permitted = append(permitted, ip)
+		}
+	}
+	return permitted
+}
@@ -0,0 +1,408 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// nssNickname names the CA in NSS databases
+	nssNickname = caName
+
+	// javaAlias names the CA in Java keystores
+	javaAlias = "go-anywhere-root-ca"
+
+	// javaStorePass is the default password of the Java cacerts keystore
+	javaStorePass = "changeit"
+)
+
+// Trust stores
+const (
+	StoreSystem = "system"
+	StoreNSS    = "nss"
+	StoreJava   = "java"
+)
+
+// TrustResult is the outcome of installing or removing the root CA for one
+// trust store.
+type TrustResult struct {
+	Store    string // StoreSystem, StoreNSS or StoreJava
+	Location string // keychain, database or keystore path
+	Skipped  string // reason the store was left alone
+	Err      error
+}
+
+// InstallCA installs the root CA, creating it if needed, into the system
+// trust store, the NSS databases of Firefox and Chromium, and the cacerts
+// keystore of the Java runtime. With user set, stores that need sudo are
+// skipped.
+func InstallCA(opts CAOptions, user bool) ([]TrustResult, error) {
+	if _, _, err := loadExistingCA(); errors.Is(err, ErrNoCA) {
+		if _, _, err = createCA(opts); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return trustCA(user), nil
+}
+
+// UninstallCA removes the root CA from the trust stores, then deletes it
+// along with the server certificates it issued.
+func UninstallCA(user bool) []TrustResult {
+	results := untrustCA(user)
+	if user {
+		// the system store may still trust the CA
+		return results
+	}
+
+	_ = os.RemoveAll(caDir())
+	_ = os.RemoveAll(leafDir())
+	return results
+}
+
+func trustCA(user bool) []TrustResult {
+	results := []TrustResult{installSystem(user)}
+	results = append(results, forNSS(user, installNSS)...)
+	return append(results, forJava(user, installJava))
+}
+
+func untrustCA(user bool) []TrustResult {
+	results := []TrustResult{uninstallSystem(user)}
+	results = append(results, forNSS(user, uninstallNSS)...)
+	return append(results, forJava(user, uninstallJava))
+}
+
+// trustErr joins the errors of the results, or returns nil if there are
+// none.
+func trustErr(results []TrustResult) error {
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", result.Store, result.Location, result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// --- system
+
+func installSystem(user bool) TrustResult {
+	result := TrustResult{Store: StoreSystem}
+
+	switch runtime.GOOS {
+	case "darwin":
+		if user {
+			result.Location = loginKeychain()
+			result.Err = runQuiet(false, "security", "add-trusted-cert", "-r", "trustRoot", "-k", result.Location, caCertPath())
+			return result
+		}
+		result.Location = "/Library/Keychains/System.keychain"
+		result.Err = runQuiet(true, "security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", result.Location, caCertPath())
+
+	case "windows":
+		result.Location = `CurrentUser\Root`
+		result.Err = runQuiet(false, "certutil", "-addstore", "-user", "Root", caCertPath())
+
+	case "linux":
+		if user {
+			result.Skipped = "needs sudo"
+			return result
+		}
+
+		// Debian/Ubuntu
+		if _, err := exec.LookPath("update-ca-certificates"); err == nil {
+			result.Location = debianAnchor
+			if result.Err = runQuiet(true, "cp", caCertPath(), debianAnchor); result.Err == nil {
+				result.Err = runQuiet(true, "update-ca-certificates", "--fresh")
+			}
+			return result
+		}
+
+		// RHEL/Fedora/Arch
+		if _, err := exec.LookPath("trust"); err == nil {
+			result.Location = "p11-kit"
+			result.Err = runQuiet(true, "trust", "anchor", "--store", caCertPath())
+			return result
+		}
+
+		result.Err = errors.New("no supported trust store manager found (need update-ca-certificates or trust)")
+
+	default:
+		result.Err = fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+
+	return result
+}
+
+func uninstallSystem(user bool) TrustResult {
+	result := TrustResult{Store: StoreSystem}
+
+	switch runtime.GOOS {
+	case "darwin":
+		if user {
+			result.Location = loginKeychain()
+			result.Err = runQuiet(false, "security", "remove-trusted-cert", caCertPath())
+			return result
+		}
+		result.Location = "/Library/Keychains/System.keychain"
+		result.Err = runQuiet(true, "security", "remove-trusted-cert", "-d", caCertPath())
+
+	case "windows":
+		result.Location = `CurrentUser\Root`
+		result.Err = runQuiet(false, "certutil", "-delstore", "-user", "Root", caName)
+
+	case "linux":
+		if user {
+			result.Skipped = "needs sudo"
+			return result
+		}
+
+		// Debian/Ubuntu
+		if _, err := exec.LookPath("update-ca-certificates"); err == nil {
+			result.Location = debianAnchor
+			if result.Err = runQuiet(true, "rm", "-f", debianAnchor); result.Err == nil {
+				result.Err = runQuiet(true, "update-ca-certificates", "--fresh")
+			}
+			return result
+		}
+
+		// RHEL/Fedora/Arch
+		if _, err := exec.LookPath("trust"); err == nil {
+			result.Location = "p11-kit"
+			result.Err = runQuiet(true, "trust", "anchor", "--remove", caCertPath())
+			return result
+		}
+
+		result.Err = errors.New("no supported trust store manager found (need update-ca-certificates or trust)")
+
+	default:
+		result.Skipped = "unsupported platform"
+	}
+
+	return result
+}
+
+// debianAnchor is where the CA is copied for update-ca-certificates.
+const debianAnchor = "/usr/local/share/ca-certificates/go-anywhere-ca.crt"
+
+func loginKeychain() string {
+	return filepath.Join(homeDir(), "Library", "Keychains", "login.keychain-db")
+}
+
+// --- NSS (Firefox, Chromium on Linux)
+
+// forNSS runs fn for every NSS database found, with the path of the NSS
+// certutil tool.
+func forNSS(user bool, fn func(certutil, db string, system bool) TrustResult) []TrustResult {
+	dbs := nssDBs(user)
+	if len(dbs) == 0 {
+		return []TrustResult{{Store: StoreNSS, Skipped: "no NSS database found"}}
+	}
+
+	certutil := nssCertutil()
+	var results []TrustResult
+	for _, db := range dbs {
+		if certutil == "" {
+			results = append(results, TrustResult{Store: StoreNSS, Location: db, Skipped: "certutil not found, install libnss3-tools or nss"})
+			continue
+		}
+		results = append(results, fn(certutil, db, !strings.HasPrefix(db, homeDir())))
+	}
+	return results
+}
+
+func installNSS(certutil, db string, system bool) TrustResult {
+	return TrustResult{
+		Store:    StoreNSS,
+		Location: db,
+		Err:      runQuiet(system, certutil, "-A", "-d", "sql:"+db, "-t", "C,,", "-n", nssNickname, "-i", caCertPath()),
+	}
+}
+
+func uninstallNSS(certutil, db string, system bool) TrustResult {
+	result := TrustResult{Store: StoreNSS, Location: db}
+	if runQuiet(system, certutil, "-L", "-d", "sql:"+db, "-n", nssNickname) != nil {
+		result.Skipped = "not installed"
+		return result
+	}
+	result.Err = runQuiet(system, certutil, "-D", "-d", "sql:"+db, "-n", nssNickname)
+	return result
+}
+
+// nssCertutil returns the path of the NSS certutil tool, or an empty string
+// if it is not installed. The certutil of Windows is a different tool.
+func nssCertutil() string {
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	if path, err := exec.LookPath("certutil"); err == nil {
+		return path
+	}
+	if runtime.GOOS == "darwin" {
+		for _, path := range []string{"/opt/homebrew/opt/nss/bin/certutil", "/usr/local/opt/nss/bin/certutil"} {
+			if fileExists(path) {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// nssDBs returns the NSS databases (in the sql format) of the current user,
+// and unless user is set, the shared system database.
+func nssDBs(user bool) []string {
+	home := homeDir()
+	patterns := []string{
+		filepath.Join(home, ".pki", "nssdb"),
+		filepath.Join(home, "snap", "chromium", "current", ".pki", "nssdb"),
+		filepath.Join(home, ".mozilla", "firefox", "*"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox", "*"),
+		filepath.Join(home, ".var", "app", "org.mozilla.firefox", ".mozilla", "firefox", "*"),
+		filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles", "*"),
+	}
+	if !user {
+		patterns = append(patterns, "/etc/pki/nssdb")
+	}
+
+	var dbs []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, dir := range matches {
+			if fileExists(filepath.Join(dir, "cert9.db")) {
+				dbs = append(dbs, dir)
+			}
+		}
+	}
+	return dbs
+}
+
+// --- Java
+
+// forJava runs fn for the cacerts keystore of the Java runtime, with the
+// path of keytool.
+func forJava(user bool, fn func(keytool, cacerts string, sudo bool) TrustResult) TrustResult {
+	keytool, cacerts := javaKeystore()
+	if keytool == "" {
+		return TrustResult{Store: StoreJava, Skipped: "no Java runtime found"}
+	}
+
+	sudo := !writable(cacerts)
+	if sudo && user {
+		return TrustResult{Store: StoreJava, Location: cacerts, Skipped: "needs sudo"}
+	}
+	return fn(keytool, cacerts, sudo)
+}
+
+func installJava(keytool, cacerts string, sudo bool) TrustResult {
+	return TrustResult{
+		Store:    StoreJava,
+		Location: cacerts,
+		Err: runQuiet(sudo, keytool, "-importcert", "-noprompt", "-alias", javaAlias, "-file", caCertPath(),
+			"-keystore", cacerts, "-storepass", javaStorePass),
+	}
+}
+
+func uninstallJava(keytool, cacerts string, sudo bool) TrustResult {
+	result := TrustResult{Store: StoreJava, Location: cacerts}
+	if runQuiet(false, keytool, "-list", "-alias", javaAlias, "-keystore", cacerts, "-storepass", javaStorePass) != nil {
+		result.Skipped = "not installed"
+		return result
+	}
+	result.Err = runQuiet(sudo, keytool, "-delete", "-alias", javaAlias, "-keystore", cacerts, "-storepass", javaStorePass)
+	return result
+}
+
+// javaKeystore returns the paths of keytool and the cacerts keystore of the
+// Java runtime at $JAVA_HOME, or else of the default runtime on macOS, or
+// else of the keytool on the PATH.
+func javaKeystore() (keytool, cacerts string) {
+	javaHome := os.Getenv("JAVA_HOME")
+	if javaHome == "" && runtime.GOOS == "darwin" {
+		// /usr/bin/keytool is a stub that forwards to the default runtime
+		if out, err := exec.Command("/usr/libexec/java_home").Output(); err == nil {
+			javaHome = strings.TrimSpace(string(out))
+		}
+	}
+	if javaHome == "" {
+		path, err := exec.LookPath("keytool")
+		if err != nil {
+			return "", ""
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		javaHome = filepath.Dir(filepath.Dir(path))
+	}
+
+	keytool = filepath.Join(javaHome, "bin", "keytool")
+	if runtime.GOOS == "windows" {
+		keytool += ".exe"
+	}
+	if !fileExists(keytool) {
+		return "", ""
+	}
+
+	for _, path := range []string{
+		filepath.Join(javaHome, "lib", "security", "cacerts"),
+		filepath.Join(javaHome, "jre", "lib", "security", "cacerts"),
+	} {
+		if fileExists(path) {
+			return keytool, path
+		}
+	}
+	return "", ""
+}
+
+// --- commands
+
+// runQuiet runs a command, with sudo if requested and not running as root
+// already, and returns its output in the error if it fails.
+func runQuiet(sudo bool, name string, args ...string) error {
+	if sudo && runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		args = append([]string{name}, args...)
+		name = "sudo"
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = os.Stdin // sudo may ask for a password
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, lastLine(msg))
+		}
+		return err
+	}
+	return nil
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// writable reports whether the current user can write the file.
+func writable(path string) bool {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	_ = f.Close()
+	return true
+}
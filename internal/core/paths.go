@@ -9,14 +9,18 @@ import (
 )
 
 func programDataDir() string {
+	return filepath.Join(homeDir(), ".local", "go-anywhere")
+}
+
+func homeDir() string {
 	usr, err := user.Current()
 	if err == nil {
-		return filepath.Join(usr.HomeDir, ".local", "go-anywhere")
+		return usr.HomeDir
 	}
 
 	home, err := os.UserHomeDir()
 	if err == nil {
-		return filepath.Join(home, ".local", "go-anywhere")
+		return home
 	}
 
 	log.Error().Str("scope", "core").Msg("Could not determine home directory")
@@ -35,3 +39,7 @@ func caCertPath() string {
 func caKeyPath() string {
 	return filepath.Join(caDir(), "rootCA.key")
 }
+
+func leafDir() string {
+	return filepath.Join(programDataDir(), "certs")
+}
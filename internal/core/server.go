@@ -5,87 +5,232 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"os"
+	"regexp"
+	"sync"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/app/server"
 
 	"github.com/AyakuraYuki/go-anywhere/internal/config"
 	"github.com/AyakuraYuki/go-anywhere/internal/handler"
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
 )
 
-func Server(cfg *config.Config) *server.Hertz {
+func Server(cfg *config.Config) (*server.Hertz, error) {
 	h := server.Default(
 		server.WithHostPorts(fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)),
 		server.WithDisablePrintRoute(true),
+		server.WithStreamBody(streamBody(cfg)),
 	)
 
-	registerMiddlewaresAndRoutes(h, cfg)
-
-	return h
-}
-
-func ServerTLS(cfg *config.Config, ips []string) (*server.Hertz, error) {
-	ca, _, err := loadOrCreateCA()
-	if err != nil {
-		return nil, err
+	// with client certificates required, plain HTTP must not serve anything
+	if cfg.MTLS == config.MTLSRequire {
+		h.Use(handler.HTTPSRedirect(cfg.PortTLS()))
 	}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AddCert(ca)
-
-	crt, key, err := GenSelfSignedCert(ips)
-	if err != nil {
+	if err := registerMiddlewaresAndRoutes(h, cfg); err != nil {
 		return nil, err
 	}
 
-	cert, err := tls.X509KeyPair(crt, key)
-	if err != nil {
-		return nil, err
-	}
+	return h, nil
+}
 
+func ServerTLS(cfg *config.Config, ips []string) (*server.Hertz, error) {
 	tlsConfig := &tls.Config{
-		// add certificate
-		Certificates: []tls.Certificate{cert},
-		MaxVersion:   tls.VersionTLS13,
-		RootCAs:      caCertPool,
+		MaxVersion: tls.VersionTLS13,
 		// cipher suites supported
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
 			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
 			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 		},
 	}
 
+	if cfg.Cert != "" {
+		// custom certificate, reloaded when the files change
+		cf, err := loadCertFile(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetCertificate = cf.GetCertificate
+	} else {
+		ca, caKey, err := loadOrCreateCA(CAOptions{Constrained: cfg.ConstrainCA, Domains: cfg.CADomains})
+		if err != nil {
+			return nil, err
+		}
+
+		// certificate for the IPs and names, other server names get their
+		// own certificate on demand. Interface IPs outside the name
+		// constraints of the CA are left out, given names fail instead.
+		names, extraIPs := serverNames(ca, cfg.SANs)
+		issuer, err := newCertIssuer(ca, caKey, append(permittedIPs(ca, ips), extraIPs...), names)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.GetCertificate = issuer.GetCertificate
+	}
+
+	// client certificates (mutual TLS)
+	if cfg.MTLS != "" {
+		clientCAs, err := clientCAPool(cfg)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.MTLS == config.MTLSVerify {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
 	h := server.Default(
 		server.WithHostPorts(fmt.Sprintf("%s:%d", cfg.Host, cfg.PortTLS())),
 		server.WithTLS(tlsConfig),
 		server.WithDisablePrintRoute(true),
+		server.WithStreamBody(streamBody(cfg)),
 	)
 
-	registerMiddlewaresAndRoutes(h, cfg)
+	if err := registerMiddlewaresAndRoutes(h, cfg); err != nil {
+		return nil, err
+	}
 
 	return h, nil
 }
 
-func registerMiddlewaresAndRoutes(h *server.Hertz, cfg *config.Config) {
-	h.Use(handler.CORS())
-	h.Use(handler.BrotliMiddleware())
+// clientCAPool returns the CAs of accepted client certificates, from
+// --client-ca or else the local root CA.
+func clientCAPool(cfg *config.Config) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	if cfg.ClientCA == "" {
+		ca, _, err := loadOrCreateCA(CAOptions{Constrained: cfg.ConstrainCA, Domains: cfg.CADomains})
+		if err != nil {
+			return nil, err
+		}
+		pool.AddCert(ca)
+		return pool, nil
+	}
+
+	data, err := os.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificate in client CA file %s", cfg.ClientCA)
+	}
+	return pool, nil
+}
+
+var (
+	liveReloadOnce sync.Once
+	liveReload     *handler.LiveReload
+)
+
+// sharedLiveReload returns the live reload watcher shared by the http and
+// https servers, or nil if live reload is disabled or cannot start.
+func sharedLiveReload(cfg *config.Config) *handler.LiveReload {
+	if !cfg.Watch {
+		return nil
+	}
+
+	liveReloadOnce.Do(func() {
+		lr, err := handler.NewLiveReload(cfg.Dir)
+		if err != nil {
+			log.Warn().Str("scope", "live-reload").Err(err).Msg("Cannot watch root directory, live reload disabled")
+			return
+		}
+		liveReload = lr
+	})
+
+	return liveReload
+}
+
+// streamBody reports whether request bodies should be streamed to the
+// handlers instead of being buffered, which is needed to accept large files.
+func streamBody(cfg *config.Config) bool {
+	return cfg.Upload || (cfg.WebDAV && cfg.WebDAVWrite)
+}
+
+func registerMiddlewaresAndRoutes(h *server.Hertz, cfg *config.Config) error {
 	h.Use(handler.LogMiddleware(cfg.EnableLog))
 
-	// HTML5 history fallback (if enabled)
-	if cfg.Fallback != "" {
-		h.Use(handler.HistoryFallbackMiddleware(cfg.Dir, handler.FallbackOptions{
-			Index:   cfg.Fallback,
-			Verbose: cfg.EnableLog,
+	// control files stay hidden from every route below, whatever rewrites
+	// or proxies
+	var shares []string
+	if cfg.WebDAV {
+		shares = append(shares, cfg.WebDAVPath)
+	}
+	h.Use(handler.HideControlFiles(shares...))
+
+	// WebDAV share (if enabled), registered ahead of the other middlewares
+	// so that CORS does not answer its OPTIONS requests, and no proxy rule
+	// or history fallback shadows it
+	if cfg.WebDAV {
+		dav := handler.WebDAV(cfg.Dir, cfg.WebDAVPath, cfg.WebDAVWrite, cfg.EnableLog)
+		for _, method := range handler.WebDAVMethods {
+			h.Handle(method, cfg.WebDAVPath, dav)
+			h.Handle(method, cfg.WebDAVPath+"/*filepath", dav)
+		}
+	}
+
+	h.Use(handler.CORS())
+
+	// on-the-fly compression (if enabled), wraps the proxy and everything
+	// below, and runs after the live reload script has been injected
+	if cfg.Compress {
+		h.Use(handler.Compress(handler.CompressOptions{
+			MinSize:   cfg.CompressMin,
+			Types:     cfg.CompressTypes,
+			CacheSize: cfg.CompressCache << 20,
 		}))
 	}
 
-	// proxy
-	if cfg.Proxy != "" {
-		h.Use(handler.Proxy(cfg.Proxy))
+	// _redirects and _headers of the root directory, rewrites may target
+	// proxied paths
+	h.Use(handler.Netlify(cfg.Dir, cfg.IndexFiles, cfg.EnableLog))
+
+	// proxy, goes before the history fallback so that proxied routes are
+	// never rewritten to the index file
+	if len(cfg.Proxies) > 0 {
+		h.Use(handler.Proxy(proxyRules(cfg.Proxies)))
+	}
+
+	// cache policy (if any), applied to the files served below
+	if len(cfg.CacheRules) > 0 {
+		h.Use(handler.CacheControl(cfg.Dir, cacheRules(cfg.CacheRules)))
+	}
+
+	// live reload (if enabled), injects into pages served below
+	if lr := sharedLiveReload(cfg); lr != nil {
+		h.Use(lr.Middleware())
+		h.GET(handler.LiveReloadPath, lr.Handler())
 	}
 
-	handler.RegisterTemplate(h)
+	// HTML5 history fallback (if enabled)
+	if cfg.Fallback != "" || len(cfg.Fallbacks) > 0 {
+		fallback, err := handler.HistoryFallbackMiddleware(cfg.Dir, handler.FallbackOptions{
+			Index:             cfg.Fallback,
+			Indexes:           fallbackIndexes(cfg.Fallbacks),
+			Rewrites:          rewriteRules(cfg.Rewrites),
+			DisableDotRule:    cfg.DisableDotRule,
+			HTMLAcceptHeaders: cfg.HTMLAccept,
+			CleanURLs:         cfg.CleanURLs,
+			Verbose:           cfg.EnableLog,
+		})
+		if err != nil {
+			return err
+		}
+		h.Use(fallback)
+	}
+
+	handler.RegisterTemplate(h, cfg)
+	handler.RegisterErrorPages(cfg)
+	h.NoRoute(handler.NotFound)
 
 	// Catch-all route for static files and directory listing
 	h.GET("/*filepath", handler.StaticFileHandler(cfg))
@@ -94,4 +239,54 @@ func registerMiddlewaresAndRoutes(h *server.Hertz, cfg *config.Config) {
 	h.GET("/", func(c context.Context, ctx *app.RequestContext) {
 		handler.StaticFileHandler(cfg)(c, ctx)
 	})
+
+	// File uploads (if enabled)
+	if cfg.Upload {
+		h.POST("/*filepath", handler.UploadHandler(cfg))
+		h.PUT("/*filepath", handler.UploadHandler(cfg))
+	}
+
+	return nil
+}
+
+func rewriteRules(rules []config.RewriteRule) []handler.RewriteRule {
+	rewrites := make([]handler.RewriteRule, 0, len(rules))
+	for _, rule := range rules {
+		rewrites = append(rewrites, handler.RewriteRule{
+			From: regexp.MustCompile(rule.From), // verified by config.Parse
+			To:   rule.To,
+		})
+	}
+	return rewrites
+}
+
+func proxyRules(rules []config.ProxyRule) []handler.ProxyRule {
+	proxies := make([]handler.ProxyRule, 0, len(rules))
+	for _, rule := range rules {
+		proxies = append(proxies, handler.ProxyRule{
+			Prefix:      rule.Prefix,
+			Target:      rule.Target,
+			StripPrefix: rule.StripPrefix,
+		})
+	}
+	return proxies
+}
+
+func cacheRules(rules []config.CacheRule) []handler.CacheRule {
+	policies := make([]handler.CacheRule, 0, len(rules))
+	for _, rule := range rules {
+		policies = append(policies, handler.CacheRule{
+			Pattern: rule.Pattern,
+			Value:   rule.Value,
+		})
+	}
+	return policies
+}
+
+func fallbackIndexes(rules []config.FallbackRule) map[string]string {
+	indexes := make(map[string]string, len(rules))
+	for _, rule := range rules {
+		indexes[rule.Prefix] = rule.Index
+	}
+	return indexes
 }
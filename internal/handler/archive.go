@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// archiveWriter adds files and directories of a tree to an archive.
+type archiveWriter interface {
+	add(name string, info fs.FileInfo, absPath string) error
+	Close() error
+}
+
+// serveArchive streams the directory absDir, served at urlPath, as a zip or
+// tar.gz archive, format is the value of the `download` query parameter. The archive is
+// written through a pipe while it is sent, nothing is buffered on disk.
+func serveArchive(c *app.RequestContext, absDir, urlPath, format string) {
+	var (
+		newWriter   func(w io.Writer) archiveWriter
+		contentType string
+	)
+
+	switch format {
+	case "zip":
+		newWriter, contentType = newZipArchive, "application/zip"
+	case "tar.gz", "tgz":
+		newWriter, contentType, format = newTarGzArchive, "application/gzip", "tar.gz"
+	default:
+		writeError(c, consts.StatusBadRequest, "Unsupported archive format %q (allowed: zip, tar.gz)", format)
+		return
+	}
+
+	name := filepath.Base(absDir)
+	c.SetContentType(contentType)
+	c.Response.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	if c.IsHead() {
+		return
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		aw := newWriter(pw)
+		err := walkArchive(absDir, urlPath, aw)
+		if closeErr := aw.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil && err != io.ErrClosedPipe {
+			log.Warn().Str("scope", "archive").Err(err).Msgf("cannot archive %s", absDir)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	// the response is sent chunked as the size is unknown
+	c.SetBodyStream(pr, -1)
+}
+
+// walkArchive adds the entries below root, served at urlPath, to aw, skipping
+// hidden files and directories the same way as the directory listing does.
+func walkArchive(root, urlPath string, aw archiveWriter) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if isHidden(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if isControlFile(filepath.ToSlash(filepath.Join(urlPath, rel))) {
+			return nil
+		}
+
+		// follow symlinks to files, symlinked directories are not descended
+		info, err := os.Stat(path)
+		if err != nil || !(info.Mode().IsRegular() || (info.IsDir() && d.IsDir())) {
+			return nil
+		}
+
+		return aw.add(filepath.ToSlash(rel), info, path)
+	})
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func newZipArchive(w io.Writer) archiveWriter {
+	return &zipArchive{zw: zip.NewWriter(w)}
+}
+
+func (a *zipArchive) add(name string, info fs.FileInfo, absPath string) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	} else {
+		header.Method = zip.Deflate
+	}
+
+	w, err := a.zw.CreateHeader(header)
+	if err != nil || info.IsDir() {
+		return err
+	}
+
+	return copyFile(w, absPath)
+}
+
+func (a *zipArchive) Close() error { return a.zw.Close() }
+
+type tarGzArchive struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzArchive(w io.Writer) archiveWriter {
+	gw := gzip.NewWriter(w)
+	return &tarGzArchive{gw: gw, tw: tar.NewWriter(gw)}
+}
+
+func (a *tarGzArchive) add(name string, info fs.FileInfo, absPath string) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err = a.tw.WriteHeader(header); err != nil || info.IsDir() {
+		return err
+	}
+
+	return copyFile(a.tw, absPath)
+}
+
+func (a *tarGzArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gw.Close()
+}
+
+func copyFile(w io.Writer, absPath string) error {
+	f, err := os.Open(absPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	_, err = io.Copy(w, f)
+	return err
+}
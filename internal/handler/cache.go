@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// CacheRule sets the Cache-Control header of responses whose path matches
+// Pattern. A pattern without a slash matches the file name in any directory
+// (e.g. "*.html"), otherwise it matches the path relative to the root
+// directory, where a trailing "/**" matches everything below a directory
+// (e.g. "assets/**").
+type CacheRule struct {
+	Pattern string
+	Value   string
+}
+
+// CacheControl applies the first matching cache rule to successful and
+// not-modified responses. Files are matched by the path of the file actually
+// served, so a history fallback request matches the rule of its index file.
+// Responses that already carry a Cache-Control header, such as proxied ones,
+// are left alone.
+func CacheControl(root string, rules []CacheRule) app.HandlerFunc {
+	return func(c context.Context, ctx *app.RequestContext) {
+		ctx.Next(c)
+
+		switch ctx.Response.StatusCode() {
+		case consts.StatusOK, consts.StatusPartialContent, consts.StatusNotModified:
+		default:
+			return
+		}
+		if len(ctx.Response.Header.Peek("Cache-Control")) > 0 {
+			return
+		}
+
+		name := string(ctx.Path())
+		if served := ctx.GetString(servedFileKey); served != "" {
+			if rel, err := filepath.Rel(root, served); err == nil {
+				name = "/" + filepath.ToSlash(rel)
+			}
+		}
+
+		for _, rule := range rules {
+			if matchCacheRule(rule.Pattern, name) {
+				ctx.Response.Header.Set("Cache-Control", rule.Value)
+				return
+			}
+		}
+	}
+}
+
+// matchCacheRule reports whether the slash-separated urlPath matches pattern.
+func matchCacheRule(pattern, urlPath string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(urlPath))
+		return ok
+	}
+
+	pattern = strings.TrimPrefix(pattern, "/")
+	urlPath = strings.TrimPrefix(urlPath, "/")
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return urlPath == dir || strings.HasPrefix(urlPath, dir+"/")
+	}
+	ok, _ := path.Match(pattern, urlPath)
+	return ok
+}
@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressTypes are the MIME type patterns compressed by default.
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/x-ndjson",
+	"application/xml",
+	"application/*+json",
+	"application/*+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// CompressOptions configures on-the-fly compression.
+type CompressOptions struct {
+	// Responses smaller than MinSize bytes are sent as is. Responses of
+	// unknown length, such as proxied chunked ones, are always compressed.
+	MinSize int
+
+	// MIME type patterns (path.Match syntax) of compressible responses
+	// (default: DefaultCompressTypes)
+	Types []string
+
+	// Size in bytes of the in-memory cache of compressed files, keyed by path
+	// and modification time (0 as disabled). Files larger than a quarter of
+	// it are compressed while they are sent instead.
+	CacheSize int
+}
+
+// Compress compresses responses on the fly with the encoding the client
+// accepts best, preferring br over zstd and gzip, like precompressed
+// sidecars. It applies to files, directory listings and proxied responses
+// that are not encoded already. Partial content, HEAD requests and event
+// streams are left alone. Responses of unknown length are flushed after every
+// chunk from upstream, so that NDJSON and long-polling responses are not held
+// back by the encoder.
+func Compress(opts CompressOptions) app.HandlerFunc {
+	if len(opts.Types) == 0 {
+		opts.Types = DefaultCompressTypes
+	}
+
+	var cache *compressCache
+	if opts.CacheSize > 0 {
+		cache = newCompressCache(opts.CacheSize)
+	}
+
+	return func(c context.Context, ctx *app.RequestContext) {
+		ctx.Next(c)
+
+		resp := &ctx.Response
+		if resp.StatusCode() != consts.StatusOK ||
+			len(resp.Header.ContentEncoding()) > 0 ||
+			resp.GetHijackWriter() != nil ||
+			!compressible(string(resp.Header.ContentType()), opts.Types) {
+			return
+		}
+
+		stream := resp.IsBodyStream()
+		size := resp.Header.ContentLength()
+		if !stream && !ctx.IsHead() {
+			size = len(resp.Body())
+		}
+		if size >= 0 && size < opts.MinSize {
+			return
+		}
+
+		resp.Header.Add("Vary", "Accept-Encoding")
+		if ctx.IsHead() {
+			return
+		}
+
+		encoding := negotiateEncoding(string(ctx.Request.Header.Peek("Accept-Encoding")))
+		if encoding == "" {
+			return
+		}
+		resp.Header.SetContentEncoding(encoding)
+		resp.Header.Del("Accept-Ranges")
+
+		// Files are cached by path and modification time
+		var key compressKey
+		if served := ctx.GetString(servedFileKey); cache != nil && served != "" {
+			if info, err := os.Stat(served); err == nil && info.Size() <= int64(cache.maxEntry) {
+				key = compressKey{path: served, encoding: encoding, modTime: info.ModTime(), size: info.Size()}
+				if body, ok := cache.get(key); ok {
+					resp.SetBody(body)
+					return
+				}
+			}
+		}
+
+		if stream && key.path == "" {
+			resp.SetBodyStreamNoReset(compressStream(resp.BodyStream(), encoding, size < 0), -1)
+			return
+		}
+
+		var buf bytes.Buffer
+		enc := newEncoder(encoding, &buf)
+		_, err := io.Copy(enc, bytes.NewReader(resp.Body())) // drains and closes a stream
+		if err == nil {
+			err = enc.Close()
+		}
+		if err != nil {
+			resp.Header.Del("Content-Encoding")
+			return
+		}
+		if key.path != "" {
+			cache.put(key, buf.Bytes())
+		}
+		resp.SetBody(buf.Bytes())
+	}
+}
+
+// compressible reports whether the content type matches one of the patterns.
+func compressible(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding returns the content coding the client accepts best, or
+// an empty string if it accepts none of them.
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := parseAcceptEncoding(acceptEncoding)
+	best, bestQ := "", 0.0
+	for _, candidate := range sidecarEncodings {
+		q, ok := accepted[candidate.encoding]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = candidate.encoding, q
+		}
+	}
+	return best
+}
+
+// newEncoder returns a writer compressing into w with the content coding.
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	switch encoding {
+	case "br":
+		return brotli.NewWriterLevel(w, 5)
+	case "zstd":
+		enc, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1)) // fails on invalid options only
+		return enc
+	default:
+		return gzip.NewWriter(w)
+	}
+}
+
+// compressStream compresses body while it is being sent, closing body once
+// it is drained or the response is aborted. With flush set, the encoder is
+// flushed after every read from body.
+func compressStream(body io.Reader, encoding string, flush bool) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := newEncoder(encoding, pw)
+		var err error
+		if flush {
+			err = copyFlush(enc, body)
+		} else {
+			_, err = io.Copy(enc, body)
+		}
+		if closeErr := enc.Close(); err == nil {
+			err = closeErr
+		}
+		if closer, ok := body.(io.Closer); ok {
+			_ = closer.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// copyFlush copies src to the encoder enc, flushing it after every read so
+// that each chunk reaches the client as soon as it arrives.
+func copyFlush(enc io.Writer, src io.Reader) error {
+	flusher, _ := enc.(interface{ Flush() error }) // gzip, brotli and zstd writers
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := enc.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if flusher != nil {
+				if ferr := flusher.Flush(); ferr != nil {
+					return ferr
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// compressKey identifies a compressed variant of a file version.
+type compressKey struct {
+	path     string
+	encoding string
+	modTime  time.Time
+	size     int64
+}
+
+// compressCache keeps compressed files up to a total size, evicting the
+// least recently used ones first.
+type compressCache struct {
+	mu       sync.Mutex
+	maxSize  int
+	maxEntry int // size limit of a single file
+	size     int
+	order    *list.List // of *compressEntry, most recently used first
+	entries  map[compressKey]*list.Element
+}
+
+type compressEntry struct {
+	key  compressKey
+	body []byte
+}
+
+func newCompressCache(maxSize int) *compressCache {
+	return &compressCache{
+		maxSize:  maxSize,
+		maxEntry: maxSize / 4,
+		order:    list.New(),
+		entries:  make(map[compressKey]*list.Element),
+	}
+}
+
+func (cc *compressCache) get(key compressKey) ([]byte, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	elem, ok := cc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cc.order.MoveToFront(elem)
+	return elem.Value.(*compressEntry).body, true
+}
+
+func (cc *compressCache) put(key compressKey, body []byte) {
+	if len(body) > cc.maxEntry {
+		return
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if _, ok := cc.entries[key]; ok {
+		return
+	}
+	cc.entries[key] = cc.order.PushFront(&compressEntry{key: key, body: body})
+	cc.size += len(body)
+
+	for cc.size > cc.maxSize {
+		oldest := cc.order.Back()
+		entry := oldest.Value.(*compressEntry)
+		cc.order.Remove(oldest)
+		delete(cc.entries, entry.key)
+		cc.size -= len(entry.body)
+	}
+}
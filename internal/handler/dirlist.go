@@ -1,12 +1,16 @@
 package handler
 
 import (
+	"cmp"
 	"fmt"
+	"io/fs"
+	"mime"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,6 +34,40 @@ type FileInfo struct {
 	ModTime string
 	IsDir   bool
 	Icon    string
+
+	// Raw values of the formatted fields above
+	SizeBytes  int64
+	ModifiedAt time.Time
+	Mode       fs.FileMode
+	MIME       string
+	LinkTarget string // target of a symbolic link
+}
+
+// IsImage reports whether the file is an image, e.g. to show a thumbnail.
+func (f FileInfo) IsImage() bool {
+	return strings.HasPrefix(f.MIME, "image/")
+}
+
+// DirEntry is a directory entry of the JSON and NDJSON listing.
+type DirEntry struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	IsDir   bool   `json:"is_dir"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mtime"` // RFC 3339
+	MIME    string `json:"mime,omitempty"`
+	Mode    string `json:"mode"`
+	Symlink string `json:"symlink,omitempty"`
+}
+
+// DirListing is the JSON listing of a directory.
+type DirListing struct {
+	Path    string     `json:"path"`
+	Parent  string     `json:"parent,omitempty"`
+	Total   int        `json:"total"` // entries matching the filter, across all pages
+	Page    int        `json:"page"`
+	Pages   int        `json:"pages"`
+	Entries []DirEntry `json:"entries"`
 }
 
 type sortableFile struct {
@@ -47,6 +85,33 @@ type DirListData struct {
 	Parent    string
 	HasParent bool
 	Files     []FileInfo
+	Upload    bool // show the upload form
+
+	Options ListOptions // sorting, filtering and pagination in effect
+	Total   int         // number of entries matching the filter
+	Pages   int         // number of pages
+}
+
+// Sort keys of ListOptions.Sort
+const (
+	SortByName  = "name"
+	SortByExt   = "ext"
+	SortBySize  = "size"
+	SortByMTime = "mtime"
+)
+
+const (
+	DefaultPerPage = 500
+	MaxPerPage     = 10000
+)
+
+// ListOptions controls the order and the slice of entries in a listing.
+type ListOptions struct {
+	Sort   string // one of the SortBy keys, empty as the default order
+	Desc   bool   // descending order
+	Filter string // glob pattern or substring the names must match
+	Page   int    // 1-based page number
+	Per    int    // entries per page, 0 as all entries
 }
 
 func formatSize(size int64) string {
@@ -66,6 +131,11 @@ func formatSize(size int64) string {
 	}
 }
 
+// isHidden reports whether a file or directory is hidden from listings.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
 func getIcon(name string, isDir bool) string {
 	if isDir {
 		return "📁"
@@ -100,7 +170,11 @@ func getIcon(name string, isDir bool) string {
 	}
 }
 
-func sortFiles(files []FileInfo, extEmptyLast bool) {
+// sortFiles orders files in place, directories always come first. By
+// default files are ordered by extension and then by name, the by argument
+// selects another key: "name", "ext", "size" or "mtime". Ties are broken by
+// name.
+func sortFiles(files []FileInfo, by string, desc, extEmptyLast bool) {
 	collator := collate.New(language.Und, collate.IgnoreCase)
 	buf := collate.Buffer{}
 
@@ -118,6 +192,43 @@ func sortFiles(files []FileInfo, extEmptyLast bool) {
 		}
 	}
 
+	compare := func(a, b sortableFile) int {
+		switch by {
+		case SortBySize:
+			if c := cmp.Compare(a.SizeBytes, b.SizeBytes); c != 0 && !a.IsDir {
+				return c
+			}
+		case SortByMTime:
+			if c := a.ModifiedAt.Compare(b.ModifiedAt); c != 0 {
+				return c
+			}
+		case SortByName:
+		default:
+			// directories in alphabetical
+			if a.IsDir {
+				break
+			}
+
+			// empty extension to last
+			if extEmptyLast {
+				if a.ext == "" && b.ext != "" {
+					return 1
+				}
+				if a.ext != "" && b.ext == "" {
+					return -1
+				}
+			}
+
+			// extensions in alphabetical
+			if c := collator.Compare(a.extKey, b.extKey); c != 0 {
+				return c
+			}
+		}
+
+		// filename in alphabetical
+		return collator.Compare(a.basenameKey, b.basenameKey)
+	}
+
 	sort.SliceStable(sortableFiles, func(i, j int) bool {
 		a := sortableFiles[i]
 		b := sortableFiles[j]
@@ -127,28 +238,10 @@ func sortFiles(files []FileInfo, extEmptyLast bool) {
 			return a.IsDir
 		}
 
-		// directories in alphabetical
-		if a.IsDir {
-			return collator.Compare(a.basenameKey, b.basenameKey) < 0
+		if desc {
+			return compare(a, b) > 0
 		}
-
-		// empty extension to last
-		if extEmptyLast {
-			if a.ext == "" && b.ext != "" {
-				return false
-			}
-			if a.ext != "" && b.ext == "" {
-				return true
-			}
-		}
-
-		// extensions in alphabetical
-		if c := collator.Compare(a.extKey, b.extKey); c != 0 {
-			return c < 0
-		}
-
-		// filename in alphabetical
-		return collator.Compare(a.basenameKey, b.basenameKey) < 0
+		return compare(a, b) < 0
 	})
 
 	for i := range files {
@@ -156,7 +249,19 @@ func sortFiles(files []FileInfo, extEmptyLast bool) {
 	}
 }
 
-func BuildDirListData(dirPath, urlPath string) (*DirListData, error) {
+// matchFilter reports whether name matches the listing filter, a glob
+// pattern when it contains wildcards, a substring otherwise. Matching is
+// case-insensitive.
+func matchFilter(name, filter string) bool {
+	name, filter = strings.ToLower(name), strings.ToLower(filter)
+	if strings.ContainsAny(filter, "*?[") {
+		matched, _ := path.Match(filter, name)
+		return matched
+	}
+	return strings.Contains(name, filter)
+}
+
+func BuildDirListData(dirPath, urlPath string, opts ListOptions) (*DirListData, error) {
 	entries, err := os.ReadDir(dirPath)
 	if err != nil {
 		return nil, err
@@ -169,8 +274,8 @@ func BuildDirListData(dirPath, urlPath string) (*DirListData, error) {
 	)
 
 	for _, entry := range entries {
-		// skip hidden entries
-		if strings.HasPrefix(entry.Name(), ".") {
+		// skip hidden entries and the files configuring the server
+		if isHidden(entry.Name()) || isControlFile(path.Join(urlPath, entry.Name())) {
 			continue
 		}
 
@@ -185,6 +290,8 @@ func BuildDirListData(dirPath, urlPath string) (*DirListData, error) {
 			fileURL     = path.Join(urlPath, url.PathEscape(name))
 			sizeStr     = ""
 			ext         = ""
+			mimeType    = ""
+			linkTarget  = ""
 		)
 
 		if entry.IsDir() {
@@ -194,6 +301,11 @@ func BuildDirListData(dirPath, urlPath string) (*DirListData, error) {
 		} else {
 			sizeStr = formatSize(info.Size())
 			ext = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+			mimeType = mime.TypeByExtension(filepath.Ext(name))
+		}
+
+		if entry.Type()&fs.ModeSymlink != 0 {
+			linkTarget, _ = os.Readlink(filepath.Join(dirPath, name))
 		}
 
 		files = append(files, FileInfo{
@@ -204,11 +316,36 @@ func BuildDirListData(dirPath, urlPath string) (*DirListData, error) {
 			ModTime: info.ModTime().Format(time.DateTime),
 			IsDir:   entry.IsDir(),
 			Icon:    getIcon(name, entry.IsDir()),
+
+			SizeBytes:  info.Size(),
+			ModifiedAt: info.ModTime(),
+			Mode:       info.Mode(),
+			MIME:       mimeType,
+			LinkTarget: linkTarget,
 		})
 	}
 
-	// sort files
-	sortFiles(files, true)
+	// filter, sort and paginate files
+	if opts.Filter != "" {
+		matched := files[:0]
+		for _, file := range files {
+			if matchFilter(strings.TrimSuffix(file.Name, "/"), opts.Filter) {
+				matched = append(matched, file)
+			}
+		}
+		files = matched
+	}
+
+	sortFiles(files, opts.Sort, opts.Desc, true)
+
+	total, pages := len(files), 1
+	if opts.Per > 0 && total > opts.Per {
+		pages = (total + opts.Per - 1) / opts.Per
+		opts.Page = min(max(opts.Page, 1), pages)
+		files = files[(opts.Page-1)*opts.Per : min(opts.Page*opts.Per, total)]
+	} else {
+		opts.Page = 1
+	}
 
 	if hasParent {
 		parentPath = path.Dir(strings.TrimSuffix(urlPath, "/"))
@@ -222,5 +359,88 @@ func BuildDirListData(dirPath, urlPath string) (*DirListData, error) {
 		Parent:    parentPath,
 		HasParent: hasParent,
 		Files:     files,
+		Options:   opts,
+		Total:     total,
+		Pages:     pages,
 	}, nil
 }
+
+// Listing builds the JSON listing from the template data.
+func (d *DirListData) Listing() *DirListing {
+	listing := &DirListing{
+		Path:    d.Path,
+		Parent:  d.Parent,
+		Total:   d.Total,
+		Page:    d.Options.Page,
+		Pages:   d.Pages,
+		Entries: make([]DirEntry, 0, len(d.Files)),
+	}
+
+	for _, file := range d.Files {
+		listing.Entries = append(listing.Entries, DirEntry{
+			Name:    strings.TrimSuffix(file.Name, "/"),
+			URL:     file.URL,
+			IsDir:   file.IsDir,
+			Size:    file.SizeBytes,
+			ModTime: file.ModifiedAt.Format(time.RFC3339),
+			MIME:    file.MIME,
+			Mode:    file.Mode.String(),
+			Symlink: file.LinkTarget,
+		})
+	}
+
+	return listing
+}
+
+// query encodes the listing options as a query string, page is the page to
+// link to.
+func (o ListOptions) query(page int) string {
+	q := url.Values{}
+	if o.Sort != "" {
+		q.Set("sort", o.Sort)
+	}
+	if o.Desc {
+		q.Set("order", "desc")
+	}
+	if o.Filter != "" {
+		q.Set("q", o.Filter)
+	}
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	if o.Per != DefaultPerPage {
+		q.Set("per", strconv.Itoa(o.Per))
+	}
+	if len(q) == 0 {
+		return "?"
+	}
+	return "?" + q.Encode()
+}
+
+// SortURL links to the listing sorted by key, clicking the current sort key
+// again reverses the order.
+func (d *DirListData) SortURL(key string) string {
+	opts := d.Options
+	opts.Desc = opts.Sort == key && !opts.Desc
+	opts.Sort = key
+	return opts.query(1)
+}
+
+// SortIndicator marks the column the listing is sorted by.
+func (d *DirListData) SortIndicator(key string) string {
+	switch {
+	case d.Options.Sort != key:
+		return ""
+	case d.Options.Desc:
+		return "▼"
+	default:
+		return "▲"
+	}
+}
+
+// HasPrev and HasNext report whether there are pages before and after the
+// current one, PrevURL and NextURL link to them.
+func (d *DirListData) HasPrev() bool   { return d.Options.Page > 1 }
+func (d *DirListData) HasNext() bool   { return d.Options.Page < d.Pages }
+func (d *DirListData) PrevURL() string { return d.Options.query(d.Options.Page - 1) }
+func (d *DirListData) NextURL() string { return d.Options.query(d.Options.Page + 1) }
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/config"
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// Messages of the common errors
+const (
+	msgNotFound  = "The requested URL was not found on this server."
+	msgForbidden = "You don't have permission to access the requested URL."
+)
+
+// ErrorData is the data of the built-in error page.
+type ErrorData struct {
+	Status     int
+	StatusText string
+	Message    string
+	Path       string
+}
+
+// errorPages locates custom error pages, nil until RegisterErrorPages.
+var errorPages *errorPageSet
+
+type errorPageSet struct {
+	dir   string         // served directory, searched for <status>.html
+	pages map[int]string // configured pages by status code
+}
+
+// RegisterErrorPages sets up the custom error pages: the page configured for
+// a status code with --error-page, otherwise <status>.html in the root
+// directory, e.g. 404.html. Configured pages must exist at startup.
+func RegisterErrorPages(cfg *config.Config) {
+	for status, page := range cfg.ErrorPages {
+		if info, err := os.Stat(page); err != nil || info.IsDir() {
+			log.Error().Str("scope", "config").Msgf("error page %s for status %d does not exist", page, status)
+			os.Exit(1)
+		}
+	}
+	errorPages = &errorPageSet{dir: cfg.Dir, pages: cfg.ErrorPages}
+}
+
+// lookup returns the custom error page for the status code, if any.
+func (s *errorPageSet) lookup(status int) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	if page, ok := s.pages[status]; ok {
+		return page, true
+	}
+	page := filepath.Join(s.dir, strconv.Itoa(status)+".html")
+	if info, err := os.Stat(page); err == nil && !info.IsDir() {
+		return page, true
+	}
+	return "", false
+}
+
+// NotFound answers requests no route matches.
+func NotFound(c context.Context, ctx *app.RequestContext) {
+	writeError(ctx, http.StatusNotFound, msgNotFound)
+}
+
+// writeError responds with an error: a JSON body when the client prefers
+// JSON, the custom error page for the status if there is one, or the
+// built-in error page.
+func writeError(c *app.RequestContext, status int, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+
+	if prefersJSON(c) {
+		c.JSON(status, utils.H{"status": status, "error": http.StatusText(status), "message": message})
+		return
+	}
+
+	if page, ok := errorPages.lookup(status); ok {
+		if content, err := os.ReadFile(page); err == nil {
+			c.Data(status, "text/html; charset=utf-8", content)
+			return
+		}
+	}
+
+	c.HTML(status, "error.gohtml", &ErrorData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    message,
+		Path:       string(c.Path()),
+	})
+}
+
+// prefersJSON reports whether the client asks for JSON rather than HTML,
+// either explicitly with ?format=json or through its Accept header.
+func prefersJSON(c *app.RequestContext) bool {
+	if format := listingFormat(c); format == listingJSON || format == listingNDJSON {
+		return true
+	}
+	accept := string(c.GetHeader("Accept"))
+	return strings.Contains(accept, "json") && !strings.Contains(accept, "text/html")
+}
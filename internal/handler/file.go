@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// fileReader streams a section of an opened file and closes the file once
+// the response has been written.
+type fileReader struct {
+	io.Reader
+	io.Closer
+}
+
+// servedFileKey stores the absolute path of the file written by serveFile in
+// the request context, for middlewares that act on the served file rather
+// than the request path.
+const servedFileKey = "anywhere.served-file"
+
+// serveFile writes the file at absPath to the response, honoring
+// conditional requests (If-None-Match, If-Modified-Since, If-Range) and
+// single byte ranges. A precompressed sidecar of the file is served instead
+// when the client accepts its encoding, see precompressed.
+//
+// Unlike app.FS it opens the file on every request instead of caching file
+// handles, so edits on disk are visible right away.
+func serveFile(c *app.RequestContext, absPath string) {
+	info, err := os.Stat(absPath)
+	if err != nil || info.IsDir() {
+		writeError(c, consts.StatusNotFound, msgNotFound)
+		return
+	}
+
+	c.Set(servedFileKey, absPath)
+
+	name, encoding := absPath, ""
+	variant, vary := precompressed(c, absPath, info)
+	if variant != nil {
+		name, encoding, info = variant.path, variant.encoding, variant.info
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		writeError(c, consts.StatusNotFound, msgNotFound)
+		return
+	}
+
+	etag := fileETag(info)
+	lastModified := info.ModTime().UTC().Format(http.TimeFormat)
+
+	if notModified(c, etag, info.ModTime()) {
+		_ = f.Close()
+		c.NotModified()
+		c.Response.Header.Set("ETag", etag)
+		c.Response.Header.Set("Last-Modified", lastModified)
+		if vary {
+			c.Response.Header.Add("Vary", "Accept-Encoding")
+		}
+		return
+	}
+
+	hdr := &c.Response.Header
+	hdr.Set("ETag", etag)
+	hdr.Set("Last-Modified", lastModified)
+	hdr.Set("Accept-Ranges", "bytes")
+	if encoding != "" {
+		hdr.Set("Content-Encoding", encoding)
+	}
+	if vary {
+		hdr.Add("Vary", "Accept-Encoding")
+	}
+
+	// keep a content type set by an earlier middleware
+	hdr.SetNoDefaultContentType(true)
+	if len(hdr.ContentType()) == 0 {
+		contentType := mime.TypeByExtension(filepath.Ext(absPath))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		c.SetContentType(contentType)
+	}
+
+	var (
+		statusCode = consts.StatusOK
+		size       = int(info.Size())
+		start      = 0
+		length     = size
+	)
+
+	if byteRange := c.Request.Header.PeekRange(); len(byteRange) > 0 && rangeFresh(c, info.ModTime()) {
+		startPos, endPos, err := app.ParseByteRange(byteRange, size)
+		if err != nil {
+			_ = f.Close()
+			hdr.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			writeError(c, consts.StatusRequestedRangeNotSatisfiable, "The requested range is not satisfiable.")
+			return
+		}
+		hdr.SetContentRange(startPos, endPos, size)
+		start, length = startPos, endPos-startPos+1
+		statusCode = consts.StatusPartialContent
+	}
+
+	c.SetStatusCode(statusCode)
+
+	if c.IsHead() {
+		_ = f.Close()
+		c.Response.ResetBody()
+		c.Response.SkipBody = true
+		hdr.SetContentLength(length)
+		return
+	}
+
+	c.SetBodyStream(fileReader{
+		Reader: io.NewSectionReader(f, int64(start), int64(length)),
+		Closer: f,
+	}, length)
+}
+
+// fileETag returns a weak entity tag derived from the size and modification
+// time of the file, which is cheap to compute and changes on every write.
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// notModified reports whether the cached copy of the client is still fresh.
+// If-None-Match takes precedence over If-Modified-Since (RFC 9110 13.2.2).
+func notModified(c *app.RequestContext, etag string, modTime time.Time) bool {
+	if ifNoneMatch := c.Request.Header.Peek("If-None-Match"); len(ifNoneMatch) > 0 {
+		return etagMatch(string(ifNoneMatch), etag)
+	}
+	return !c.IfModifiedSince(modTime)
+}
+
+// etagMatch reports whether etag is in the If-None-Match list, using the weak
+// comparison.
+func etagMatch(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeFresh reports whether a range request may be answered partially. An
+// If-Range date must match the modification time exactly, and an If-Range
+// entity tag never matches as weak tags are unfit for ranges.
+func rangeFresh(c *app.RequestContext, modTime time.Time) bool {
+	ifRange := c.Request.Header.Peek("If-Range")
+	if len(ifRange) == 0 {
+		return true
+	}
+	t, err := http.ParseTime(string(ifRange))
+	return err == nil && t.Equal(modTime.UTC().Truncate(time.Second))
+}
+
+// sidecar is a precompressed variant of a file, like app.js.br for app.js.
+type sidecar struct {
+	path     string
+	encoding string
+	info     os.FileInfo
+}
+
+// sidecarEncodings lists the content codings of sidecar files by extension,
+// in order of preference when the client accepts several equally.
+var sidecarEncodings = []struct{ encoding, ext string }{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// precompressed returns the sidecar of the file at absPath that the client
+// accepts best according to Accept-Encoding, or nil to serve the file as is.
+// Sidecars older than the file are considered stale and ignored. vary reports
+// whether any sidecar exists, in which case the response depends on
+// Accept-Encoding.
+func precompressed(c *app.RequestContext, absPath string, info os.FileInfo) (best *sidecar, vary bool) {
+	accepted := parseAcceptEncoding(string(c.Request.Header.Peek("Accept-Encoding")))
+	bestQ := 0.0
+	for _, candidate := range sidecarEncodings {
+		sidecarInfo, err := os.Stat(absPath + candidate.ext)
+		if err != nil || sidecarInfo.IsDir() || sidecarInfo.ModTime().Before(info.ModTime()) {
+			continue
+		}
+		vary = true
+
+		q, ok := accepted[candidate.encoding]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best = &sidecar{path: absPath + candidate.ext, encoding: candidate.encoding, info: sidecarInfo}
+			bestQ = q
+		}
+	}
+	return best, vary
+}
+
+// parseAcceptEncoding maps the content codings of an Accept-Encoding header
+// to their quality values.
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[coding] = q
+	}
+	return accepted
+}
@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/hertz/pkg/protocol/http1/resp"
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// LiveReloadPath is the Server-Sent Events endpoint the injected client
+// script listens on.
+const LiveReloadPath = "/__anywhere/livereload"
+
+const (
+	liveReloadEventReload = "reload" // reload the whole page
+	liveReloadEventCSS    = "css"    // only stylesheets changed, swap them
+
+	liveReloadDebounce  = 100 * time.Millisecond
+	liveReloadHeartbeat = 30 * time.Second
+)
+
+// liveReloadScript is injected before </body> of served HTML pages.
+const liveReloadScript = `<script>
+(function () {
+  var source = new EventSource("` + LiveReloadPath + `");
+  source.onmessage = function (e) {
+    if (e.data !== "` + liveReloadEventCSS + `") {
+      location.reload();
+      return;
+    }
+    document.querySelectorAll('link[rel="stylesheet"]').forEach(function (link) {
+      var url = new URL(link.href);
+      url.searchParams.set("livereload", Date.now());
+      var next = link.cloneNode();
+      next.href = url.toString();
+      next.onload = function () { link.remove(); };
+      link.after(next);
+    });
+  };
+})();
+</script>
+`
+
+// LiveReload watches a directory tree and notifies connected browsers when
+// files change.
+type LiveReload struct {
+	watcher *fsnotify.Watcher
+
+	mu      sync.Mutex
+	clients map[chan string]struct{}
+}
+
+// NewLiveReload starts watching dir recursively, hidden files and
+// directories are ignored.
+func NewLiveReload(dir string) (*LiveReload, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	lr := &LiveReload{
+		watcher: watcher,
+		clients: make(map[chan string]struct{}),
+	}
+
+	if err = lr.watchTree(dir); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	go lr.run()
+
+	return lr, nil
+}
+
+// watchTree adds dir and all its non-hidden subdirectories to the watcher.
+func (lr *LiveReload) watchTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return lr.watcher.Add(path)
+	})
+}
+
+func (lr *LiveReload) run() {
+	var (
+		timer   *time.Timer
+		cssOnly = true
+		pending = make(chan struct{}, 1)
+	)
+
+	for {
+		select {
+		case event, ok := <-lr.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod || strings.HasPrefix(filepath.Base(event.Name), ".") {
+				continue
+			}
+
+			// follow newly created directories
+			if event.Op.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err = lr.watchTree(event.Name); err != nil {
+						log.Warn().Str("scope", "live-reload").Err(err).Msgf("cannot watch %s", event.Name)
+					}
+				}
+			}
+
+			if !strings.EqualFold(filepath.Ext(event.Name), ".css") {
+				cssOnly = false
+			}
+
+			// coalesce bursts of events, editors often write a file in
+			// several steps
+			if timer == nil {
+				timer = time.AfterFunc(liveReloadDebounce, func() { pending <- struct{}{} })
+			}
+
+		case <-pending:
+			if cssOnly {
+				lr.broadcast(liveReloadEventCSS)
+			} else {
+				lr.broadcast(liveReloadEventReload)
+			}
+			timer, cssOnly = nil, true
+
+		case err, ok := <-lr.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn().Str("scope", "live-reload").Err(err).Msg("watcher error")
+		}
+	}
+}
+
+func (lr *LiveReload) broadcast(event string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	for ch := range lr.clients {
+		select {
+		case ch <- event:
+		default: // the client has a pending event already
+		}
+	}
+}
+
+func (lr *LiveReload) subscribe() chan string {
+	ch := make(chan string, 1)
+	lr.mu.Lock()
+	lr.clients[ch] = struct{}{}
+	lr.mu.Unlock()
+	return ch
+}
+
+func (lr *LiveReload) unsubscribe(ch chan string) {
+	lr.mu.Lock()
+	delete(lr.clients, ch)
+	lr.mu.Unlock()
+}
+
+// Handler serves the Server-Sent Events stream of change events.
+func (lr *LiveReload) Handler() app.HandlerFunc {
+	return func(c context.Context, ctx *app.RequestContext) {
+		events := lr.subscribe()
+		defer lr.unsubscribe(events)
+
+		ctx.SetStatusCode(consts.StatusOK)
+		ctx.SetContentType("text/event-stream")
+		ctx.Response.Header.Set("Cache-Control", "no-cache")
+		ctx.Response.HijackWriter(resp.NewChunkedBodyWriter(&ctx.Response, ctx.GetWriter()))
+
+		heartbeat := time.NewTicker(liveReloadHeartbeat)
+		defer heartbeat.Stop()
+
+		// a heartbeat comment also tells whether the client is still there
+		message := "retry: 1000\n\n"
+		for {
+			_, _ = ctx.Write([]byte(message))
+			if err := ctx.Flush(); err != nil {
+				return
+			}
+
+			select {
+			case event := <-events:
+				message = fmt.Sprintf("data: %s\n\n", event)
+			case <-heartbeat.C:
+				message = ": heartbeat\n\n"
+			}
+		}
+	}
+}
+
+// Middleware injects the live reload client script into HTML responses.
+func (lr *LiveReload) Middleware() app.HandlerFunc {
+	return func(c context.Context, ctx *app.RequestContext) {
+		ctx.Next(c)
+
+		response := &ctx.Response
+		if response.StatusCode() != consts.StatusOK || ctx.IsHead() {
+			return
+		}
+		if !bytes.HasPrefix(response.Header.ContentType(), []byte("text/html")) {
+			return
+		}
+		if len(response.Header.Peek("Content-Encoding")) > 0 {
+			return
+		}
+		if response.IsBodyStream() && response.Header.ContentLength() < 0 {
+			return // unbounded stream
+		}
+
+		response.SetBody(injectBeforeBodyEnd(response.Body(), liveReloadScript))
+	}
+}
+
+// injectBeforeBodyEnd inserts snippet before the last </body> tag, or
+// appends it when there is none.
+func injectBeforeBodyEnd(html []byte, snippet string) []byte {
+	// ASCII-only case folding, the offsets must hold in html itself
+	const tag = "</body>"
+	i := len(html) - len(tag)
+	for ; i >= 0; i-- {
+		if html[i] == '<' && asciiEqualFold(html[i:i+len(tag)], tag) {
+			break
+		}
+	}
+	if i < 0 {
+		return append(html, snippet...)
+	}
+
+	out := make([]byte, 0, len(html)+len(snippet))
+	out = append(out, html[:i]...)
+	out = append(out, snippet...)
+	return append(out, html[i:]...)
+}
+
+// asciiEqualFold reports whether b equals s, ignoring the case of ASCII
+// letters only.
+func asciiEqualFold(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := range len(b) {
+		x, y := b[i], s[i]
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if 'A' <= y && y <= 'Z' {
+			y += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/network"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// ClientSubject returns the subject of the verified client certificate of
+// the request (with mutual TLS), or an empty string without one.
+func ClientSubject(ctx *app.RequestContext) string {
+	conn, ok := ctx.GetConn().(network.ConnTLSer)
+	if !ok {
+		return ""
+	}
+
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.String()
+}
+
+// HTTPSRedirect redirects every request to the HTTPS server at port, so that
+// plain HTTP does not bypass client certificate authentication.
+func HTTPSRedirect(port int) app.HandlerFunc {
+	return func(c context.Context, ctx *app.RequestContext) {
+		host := string(ctx.Host())
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+
+		u := ctx.Request.URI()
+		target := "https://" + net.JoinHostPort(host, strconv.Itoa(port)) + string(u.RequestURI())
+		ctx.Redirect(consts.StatusTemporaryRedirect, []byte(target))
+		ctx.Abort()
+	}
+}
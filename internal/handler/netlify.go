@@ -0,0 +1,449 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/client"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/hertz-contrib/reverseproxy"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// Netlify-style rule files read from the root directory
+const (
+	RedirectsFile = "_redirects"
+	HeadersFile   = "_headers"
+)
+
+// RedirectRule is a rule of a _redirects file, e.g.
+//
+//	/news/:year/*  /blog/:year/:splat  301
+//	/store id=:id  /products/:id       302
+//	/*             /index.html         200
+type RedirectRule struct {
+	RewriteRule                   // request path pattern and target
+	Query       map[string]string // required query parameters, `:name` captures the value
+	Status      int               // 3xx redirects, 200 rewrites, others rewrite with that status
+	Force       bool              // apply even if a file exists at the path (`200!`)
+}
+
+// HeaderRule sets response headers for paths matching From, from a _headers
+// file.
+type HeaderRule struct {
+	From    *regexp.Regexp
+	Headers [][2]string // name and value pairs
+}
+
+// placeholderPattern matches `:name` placeholders of redirect targets.
+var placeholderPattern = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+
+// reloadInterval is how often the rule files are checked for changes.
+const reloadInterval = time.Second
+
+// Netlify applies the _redirects and _headers files of dir, like Netlify and
+// compatible hosts do, and picks up changes to them while running.
+//
+// The first matching redirect rule wins. Unless forced, a rule is skipped when
+// the path is served by a file (shadowing), a directory counts if it has one
+// of indexFiles. Header rules apply to every response whose request path
+// matches, values of a header set by several rules are joined with commas.
+func Netlify(dir string, indexFiles []string, verbose bool) app.HandlerFunc {
+	site := &netlifySite{
+		dir:     dir,
+		proxies: make(map[string]*reverseproxy.ReverseProxy),
+	}
+
+	logger := func(format string, args ...any) {
+		if verbose {
+			log.Trace().Str("scope", "netlify").Msgf(format, args...)
+		}
+	}
+
+	return func(c context.Context, ctx *app.RequestContext) {
+		redirects, headers := site.rules()
+		urlPath := string(ctx.Path())
+
+		defer applyHeaderRules(ctx, headers, urlPath)
+
+		parsedURL, err := url.Parse(string(ctx.Request.URI().RequestURI()))
+		if err != nil {
+			ctx.Next(c)
+			return
+		}
+
+		var shadowed *bool
+		for _, rule := range redirects {
+			matches := rule.From.FindStringSubmatch(urlPath)
+			if matches == nil || !matchQuery(parsedURL.Query(), rule.Query) {
+				continue
+			}
+
+			if !rule.Force {
+				if shadowed == nil {
+					exists := pathExists(dir, urlPath, indexFiles)
+					shadowed = &exists
+				}
+				if *shadowed {
+					logger("Not redirecting %s — a file exists at the path.", urlPath)
+					continue
+				}
+			}
+
+			target, err := evaluateRewriteRule(parsedURL, matches, rule.To)
+			if err != nil {
+				logger("Not redirecting %s — %v", urlPath, err)
+				continue
+			}
+			// pass the query string through, unless the rule consumed it
+			if len(rule.Query) == 0 && !strings.Contains(target, "?") && parsedURL.RawQuery != "" {
+				target += "?" + parsedURL.RawQuery
+			}
+
+			switch {
+			case rule.Status >= 300 && rule.Status < 400:
+				logger("Redirecting %s to %s (%d)", urlPath, target, rule.Status)
+				ctx.Redirect(rule.Status, []byte(target))
+			case isAbsoluteURL(target):
+				logger("Proxying %s to %s", urlPath, target)
+				site.proxy(c, ctx, target, rule.Status)
+			default:
+				logger("Rewriting %s to %s (%d)", urlPath, target, rule.Status)
+				ctx.Request.SetRequestURI(target)
+				ctx.Set(rewrittenKey, true)
+				ctx.Next(c)
+				if rule.Status != consts.StatusOK && ctx.Response.StatusCode() == consts.StatusOK {
+					ctx.SetStatusCode(rule.Status)
+				}
+			}
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next(c)
+	}
+}
+
+// rewrittenKey marks in the request context a request rewritten by a
+// _redirects rule, so that the history fallback leaves it as it is.
+const rewrittenKey = "anywhere.rewritten"
+
+// netlifySite holds the rules of the rule files, reloaded when they change.
+type netlifySite struct {
+	dir string
+
+	mu        sync.Mutex
+	checked   time.Time
+	stamps    [2]string // size and modification time of the rule files
+	redirects []RedirectRule
+	headers   []HeaderRule
+	proxies   map[string]*reverseproxy.ReverseProxy // by target origin
+}
+
+// rules returns the current rules, reloading files changed since the last
+// check.
+func (s *netlifySite) rules() ([]RedirectRule, []HeaderRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.checked) < reloadInterval {
+		return s.redirects, s.headers
+	}
+	s.checked = time.Now()
+
+	for i, name := range []string{RedirectsFile, HeadersFile} {
+		file := filepath.Join(s.dir, name)
+		stamp := ""
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			stamp = fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+		}
+		if stamp == s.stamps[i] {
+			continue
+		}
+		s.stamps[i] = stamp
+
+		var content []byte
+		if stamp != "" {
+			var err error
+			if content, err = os.ReadFile(file); err != nil {
+				log.Warn().Str("scope", "netlify").Err(err).Msgf("cannot read %s", file)
+				continue
+			}
+		}
+
+		var warnings []error
+		if name == RedirectsFile {
+			s.redirects, warnings = ParseRedirects(content)
+			if stamp != "" {
+				log.Info().Str("scope", "netlify").Msgf("loaded %d rules from %s", len(s.redirects), file)
+			}
+		} else {
+			s.headers, warnings = ParseHeaders(content)
+			if stamp != "" {
+				log.Info().Str("scope", "netlify").Msgf("loaded %d rules from %s", len(s.headers), file)
+			}
+		}
+		for _, warning := range warnings {
+			log.Warn().Str("scope", "netlify").Msgf("%s:%v", file, warning)
+		}
+	}
+
+	return s.redirects, s.headers
+}
+
+// proxy forwards the request to the absolute target URL of a 200 rule.
+func (s *netlifySite) proxy(c context.Context, ctx *app.RequestContext, target string, status int) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		writeError(ctx, consts.StatusBadGateway, "Invalid proxy target %q.", target)
+		return
+	}
+	origin := targetURL.Scheme + "://" + targetURL.Host
+
+	s.mu.Lock()
+	proxy, ok := s.proxies[origin]
+	if !ok {
+		proxy, err = reverseproxy.NewSingleHostReverseProxy(origin, client.WithResponseBodyStream(true))
+		if err == nil {
+			s.proxies[origin] = proxy
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeError(ctx, consts.StatusBadGateway, "Cannot proxy to %s: %v", origin, err)
+		return
+	}
+
+	ctx.Request.SetRequestURI(targetURL.RequestURI())
+	proxy.ServeHTTP(c, ctx)
+	if status != consts.StatusOK && ctx.Response.StatusCode() == consts.StatusOK {
+		ctx.SetStatusCode(status)
+	}
+}
+
+// ParseRedirects parses the content of a _redirects file. Invalid lines are
+// skipped and reported as warnings.
+func ParseRedirects(content []byte) (rules []RedirectRule, warnings []error) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule, err := parseRedirectRule(fields)
+		if err != nil {
+			warnings = append(warnings, fmt.Errorf("%d: %w", lineNo, err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, warnings
+}
+
+// parseRedirectRule parses the fields `from [key=value...] to [status[!]]`.
+func parseRedirectRule(fields []string) (RedirectRule, error) {
+	rule := RedirectRule{Query: make(map[string]string), Status: 301}
+
+	from := fields[0]
+	if !strings.HasPrefix(from, "/") {
+		return rule, fmt.Errorf("unsupported source %q (must be a path)", from)
+	}
+
+	rest := fields[1:]
+	for len(rest) > 0 && strings.Contains(rest[0], "=") && !strings.HasPrefix(rest[0], "/") && !isAbsoluteURL(rest[0]) {
+		key, value, _ := strings.Cut(rest[0], "=")
+		rule.Query[key] = value
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return rule, fmt.Errorf("missing target of %q", from)
+	}
+	to := rest[0]
+
+	if len(rest) > 1 {
+		status, force := strings.CutSuffix(rest[1], "!")
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 200 || code > 599 {
+			return rule, fmt.Errorf("invalid status %q", rest[1])
+		}
+		rule.Status, rule.Force = code, force
+	}
+	if len(rest) > 2 {
+		return rule, fmt.Errorf("unsupported conditions %q", strings.Join(rest[2:], " "))
+	}
+
+	re, err := compilePathPattern(from)
+	if err != nil {
+		return rule, err
+	}
+	rule.From = re
+	rule.To = redirectTarget(re, to, rule.Query)
+	return rule, nil
+}
+
+// redirectTarget returns a RewriteFunc expanding the `:splat` and `:name`
+// placeholders of to with the values captured from the path and the query.
+func redirectTarget(from *regexp.Regexp, to string, query map[string]string) RewriteFunc {
+	return func(ctx RewriteContext) string {
+		values := make(map[string]string)
+		for i, name := range from.SubexpNames() {
+			if name != "" && i < len(ctx.Match) {
+				values[name] = ctx.Match[i]
+			}
+		}
+		params := ctx.ParsedURL.Query()
+		for key, value := range query {
+			if name, ok := strings.CutPrefix(value, ":"); ok {
+				values[name] = params.Get(key)
+			}
+		}
+
+		return placeholderPattern.ReplaceAllStringFunc(to, func(placeholder string) string {
+			if value, ok := values[placeholder[1:]]; ok {
+				return value
+			}
+			return placeholder
+		})
+	}
+}
+
+// ParseHeaders parses the content of a _headers file, a path pattern line
+// followed by indented `Name: value` lines. Invalid lines are skipped and
+// reported as warnings.
+func ParseHeaders(content []byte) (rules []HeaderRule, warnings []error) {
+	var current *HeaderRule
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// path lines start at the beginning of the line
+		if line[0] != ' ' && line[0] != '\t' {
+			re, err := compilePathPattern(trimmed)
+			if err != nil {
+				warnings = append(warnings, fmt.Errorf("%d: %w", lineNo, err))
+				current = nil
+				continue
+			}
+			rules = append(rules, HeaderRule{From: re})
+			current = &rules[len(rules)-1]
+			continue
+		}
+
+		name, value, ok := strings.Cut(trimmed, ":")
+		if !ok || current == nil || strings.TrimSpace(name) == "" {
+			warnings = append(warnings, fmt.Errorf("%d: invalid header %q", lineNo, trimmed))
+			continue
+		}
+		current.Headers = append(current.Headers, [2]string{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+	return rules, warnings
+}
+
+// compilePathPattern compiles a rule path: `:name` matches a path segment, `*`
+// the rest of the path as the `splat` placeholder. A trailing slash is
+// optional.
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("invalid path %q (must start with '/')", pattern)
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for _, segment := range strings.Split(strings.TrimSuffix(pattern, "/"), "/")[1:] {
+		switch {
+		case segment == "*":
+			b.WriteString("(?:/(?P<splat>.*))?")
+		case strings.HasPrefix(segment, ":") && placeholderPattern.MatchString(segment):
+			b.WriteString("/(?P<" + segment[1:] + ">[^/]+)")
+		default:
+			b.WriteString("/")
+			for i, part := range strings.Split(segment, "*") {
+				if i > 0 {
+					b.WriteString("(?P<splat>.*)")
+				}
+				b.WriteString(regexp.QuoteMeta(part))
+			}
+		}
+	}
+	b.WriteString("/?$")
+
+	return regexp.Compile(b.String())
+}
+
+// matchQuery reports whether the query has the required parameters.
+func matchQuery(query url.Values, required map[string]string) bool {
+	for key, value := range required {
+		if !query.Has(key) {
+			return false
+		}
+		if !strings.HasPrefix(value, ":") && query.Get(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// applyHeaderRules sets the headers of the rules matching urlPath.
+func applyHeaderRules(ctx *app.RequestContext, rules []HeaderRule, urlPath string) {
+	values := make(map[string][]string)
+	var names []string
+	for _, rule := range rules {
+		if !rule.From.MatchString(urlPath) {
+			continue
+		}
+		for _, header := range rule.Headers {
+			if _, ok := values[header[0]]; !ok {
+				names = append(names, header[0])
+			}
+			values[header[0]] = append(values[header[0]], header[1])
+		}
+	}
+	for _, name := range names {
+		ctx.Response.Header.Set(name, strings.Join(values[name], ", "))
+	}
+}
+
+// pathExists reports whether a file, or a directory with an index file,
+// serves urlPath.
+func pathExists(dir, urlPath string, indexFiles []string) bool {
+	absPath, ok := resolvePath(dir, urlPath)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return false
+	}
+	if !info.IsDir() {
+		return true
+	}
+	for _, index := range indexFiles {
+		if indexInfo, err := os.Stat(filepath.Join(absPath, index)); err == nil && !indexInfo.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// isAbsoluteURL reports whether s is an http or https URL.
+func isAbsoluteURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
@@ -2,29 +2,143 @@ package handler
 
 import (
 	"context"
+	"net/url"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/client"
 	"github.com/hertz-contrib/reverseproxy"
+	hzws "github.com/hertz-contrib/websocket"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
 )
 
-func Proxy(proxyURL string) app.HandlerFunc {
-	isURL := strings.HasPrefix(proxyURL, "https://") || strings.HasPrefix(proxyURL, "http://")
-	if !isURL {
-		return func(c context.Context, ctx *app.RequestContext) {
-			ctx.Next(c)
+// ProxyRule forwards requests under Prefix to Target.
+type ProxyRule struct {
+	Prefix      string // path prefix, matched on segment boundaries
+	Target      string // backend URL, its path is joined with the request path
+	StripPrefix bool   // remove Prefix from the request path before forwarding
+}
+
+type proxyRoute struct {
+	ProxyRule
+	proxy    *reverseproxy.ReverseProxy
+	wsTarget *url.URL // Target with ws:// or wss:// scheme
+}
+
+// wsUpgrader accepts any origin, the Origin header is forwarded so the
+// backend can decide for itself.
+var wsUpgrader = &hzws.HertzUpgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(*app.RequestContext) bool { return true },
+}
+
+// Proxy forwards requests to the rule with the longest matching prefix.
+// Requests that no rule matches are passed to the next handler.
+//
+// Responses are streamed instead of buffered, so that Server-Sent Events
+// and other long-polling responses reach the client as soon as the backend
+// flushes them. WebSocket upgrade requests are tunneled to the backend.
+func Proxy(rules []ProxyRule) app.HandlerFunc {
+	var routes []proxyRoute
+	for _, rule := range rules {
+		proxy, err := reverseproxy.NewSingleHostReverseProxy(rule.Target, client.WithResponseBodyStream(true))
+		if err != nil {
+			log.Warn().Str("scope", "proxy").Err(err).Msgf("cannot proxy %s to %s, skipped", rule.Prefix, rule.Target)
+			continue
 		}
-	}
 
-	proxy, err := reverseproxy.NewSingleHostReverseProxy(proxyURL)
-	if err != nil {
-		return func(c context.Context, ctx *app.RequestContext) {
-			ctx.Next(c)
+		wsTarget, err := url.Parse(rule.Target)
+		if err != nil {
+			log.Warn().Str("scope", "proxy").Err(err).Msgf("cannot proxy %s to %s, skipped", rule.Prefix, rule.Target)
+			continue
 		}
+		wsTarget.Scheme = strings.Replace(wsTarget.Scheme, "http", "ws", 1)
+
+		routes = append(routes, proxyRoute{ProxyRule: rule, proxy: proxy, wsTarget: wsTarget})
 	}
 
+	// longest prefix first
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(strings.TrimSuffix(routes[i].Prefix, "/")) > len(strings.TrimSuffix(routes[j].Prefix, "/"))
+	})
+
 	return func(c context.Context, ctx *app.RequestContext) {
-		proxy.ServeHTTP(c, ctx)
-		ctx.Abort()
+		urlPath := string(ctx.Path())
+
+		for _, route := range routes {
+			rest, ok := matchPrefix(urlPath, route.Prefix)
+			if !ok {
+				continue
+			}
+
+			if route.StripPrefix {
+				ctx.Request.URI().SetPath(rest)
+			}
+
+			if isWebSocketUpgrade(ctx) {
+				target := *route.wsTarget
+				target.Path = joinURLPath(target.Path, string(ctx.Request.URI().Path()))
+				target.RawQuery = string(ctx.Request.URI().QueryString())
+
+				reverseproxy.NewWSReverseProxy(target.String(), reverseproxy.WithUpgrader(wsUpgrader)).ServeHTTP(c, ctx)
+			} else {
+				route.proxy.ServeHTTP(c, ctx)
+			}
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next(c)
+	}
+}
+
+// matchPrefix reports whether urlPath is prefix or lies beneath it, `/api`
+// matches `/api` and `/api/users` but not `/apis`. The remaining path is
+// returned with a leading slash.
+func matchPrefix(urlPath, prefix string) (rest string, ok bool) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.HasPrefix(urlPath, prefix) {
+		return "", false
+	}
+
+	rest = urlPath[len(prefix):]
+	switch {
+	case rest == "":
+		return "/", true
+	case rest[0] == '/':
+		return rest, true
+	default:
+		return "", false
+	}
+}
+
+// isWebSocketUpgrade reports whether the request asks to switch to the
+// websocket protocol (`Connection: Upgrade` and `Upgrade: websocket`).
+func isWebSocketUpgrade(ctx *app.RequestContext) bool {
+	if !strings.EqualFold(string(ctx.GetHeader("Upgrade")), "websocket") {
+		return false
+	}
+	for _, token := range strings.Split(string(ctx.GetHeader("Connection")), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+// joinURLPath joins the target path and the request path like the http
+// reverse proxy does, keeping a trailing slash of the request path.
+func joinURLPath(targetPath, reqPath string) string {
+	if targetPath == "" || targetPath == "/" {
+		return reqPath
+	}
+	joined := path.Join(targetPath, reqPath)
+	if strings.HasSuffix(reqPath, "/") && !strings.HasSuffix(joined, "/") {
+		joined += "/"
 	}
+	return joined
 }
@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/gorilla/websocket"
+)
+
+// startProxy serves the proxy rules on a random local port and returns its
+// address.
+func startProxy(t *testing.T, rules ...ProxyRule) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := server.New(server.WithListener(ln), server.WithDisablePrintRoute(true))
+	h.Use(Proxy(rules))
+	go h.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = h.Shutdown(ctx)
+	})
+
+	return ln.Addr().String()
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(typ, append([]byte(r.URL.Path+" "), msg...)); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	addr := startProxy(t, ProxyRule{Prefix: "/ws", Target: backend.URL + "/v1", StripPrefix: true})
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws/echo", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for i, frame := range []struct {
+		typ int
+		msg string
+	}{
+		{websocket.TextMessage, "hello"},
+		{websocket.BinaryMessage, "\x00\x01\x02"},
+		{websocket.TextMessage, strings.Repeat("x", 64<<10)},
+	} {
+		if err = conn.WriteMessage(frame.typ, []byte(frame.msg)); err != nil {
+			t.Fatalf("frame %d: write: %v", i, err)
+		}
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("frame %d: read: %v", i, err)
+		}
+		if want := "/v1/echo " + frame.msg; typ != frame.typ || string(msg) != want {
+			t.Errorf("frame %d: got type %d %.32q, want type %d %.32q", i, typ, msg, frame.typ, want)
+		}
+	}
+
+	// the close handshake is tunneled as well
+	if err = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, _, err = conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("close: got %v, want a normal closure", err)
+	}
+}
+
+func TestProxyEventStream(t *testing.T) {
+	next := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			_, _ = fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+	defer close(next)
+
+	addr := startProxy(t, ProxyRule{Prefix: "/events", Target: backend.URL})
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + addr + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// every event must arrive while the backend still holds the response
+	// open, waiting for the test to ask for the next one
+	r := bufio.NewReader(resp.Body)
+	for i := 1; i <= 3; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("data: %d\n", i); line != want {
+			t.Fatalf("event %d: got %q, want %q", i, line, want)
+		}
+		if _, err = r.ReadString('\n'); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		next <- struct{}{}
+	}
+
+	if rest, _ := io.ReadAll(r); len(rest) != 0 {
+		t.Errorf("unexpected trailing data %q", rest)
+	}
+}
+
+func TestProxyStripPrefix(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+
+	addr := startProxy(t,
+		ProxyRule{Prefix: "/api", Target: backend.URL},
+		ProxyRule{Prefix: "/api/v2/", Target: backend.URL + "/next", StripPrefix: true},
+		ProxyRule{Prefix: "/auth", Target: backend.URL + "/v2", StripPrefix: true},
+	)
+
+	for _, tt := range []struct {
+		path string
+		want string // path seen by the backend, empty as not proxied
+	}{
+		{"/api", "/api"},
+		{"/api/users?id=1", "/api/users?id=1"},
+		{"/apis", ""},
+		{"/api/v2", "/next/"},
+		{"/api/v2/users", "/next/users"},
+		{"/api/v2x", "/api/v2x"},
+		{"/auth/login", "/v2/login"},
+		{"/auth/login/", "/v2/login/"},
+		{"/authz", ""},
+		{"/", ""},
+	} {
+		resp, err := http.Get("http://" + addr + tt.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if tt.want == "" {
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("%s: got %d %q, want it not proxied", tt.path, resp.StatusCode, body)
+			}
+			continue
+		}
+		if string(body) != tt.want {
+			t.Errorf("%s: backend got %q, want %q", tt.path, body, tt.want)
+		}
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		urlPath, prefix string
+		rest            string
+		ok              bool
+	}{
+		{"/api", "/api", "/", true},
+		{"/api/", "/api", "/", true},
+		{"/api/users", "/api", "/users", true},
+		{"/api/users", "/api/", "/users", true},
+		{"/apis", "/api", "", false},
+		{"/ap", "/api", "", false},
+		{"/api", "/api/", "/", true},
+		{"/anything", "/", "/anything", true},
+		{"/anything", "", "/anything", true},
+	} {
+		rest, ok := matchPrefix(tt.urlPath, tt.prefix)
+		if rest != tt.rest || ok != tt.ok {
+			t.Errorf("matchPrefix(%q, %q) = %q, %v, want %q, %v", tt.urlPath, tt.prefix, rest, ok, tt.rest, tt.ok)
+		}
+	}
+}
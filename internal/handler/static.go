@@ -1,19 +1,23 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
-	"html/template"
-	"mime"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
-	"github.com/cloudwego/hertz/pkg/app/server"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
 
 	"github.com/AyakuraYuki/go-anywhere/internal/config"
@@ -23,13 +27,6 @@ import (
 var (
 	//go:embed templates
 	templateFS embed.FS
-
-	nonCacheRootFS = &app.FS{
-		Root:               "/",
-		GenerateIndexPages: true,
-		Compress:           false,
-		AcceptByteRange:    true,
-	}
 )
 
 type Config struct {
@@ -39,9 +36,13 @@ type Config struct {
 }
 
 type FallbackOptions struct {
-	// Fallback file path (default: "/index.html")
+	// Fallback file path (default: "/index.html" unless Indexes are set)
 	Index string
 
+	// Fallback file paths of path prefixes, e.g. "/admin" to
+	// "/admin/index.html", the longest matching prefix wins over Index
+	Indexes map[string]string
+
 	// Custom rewrite rules, evaluated in order
 	Rewrites []RewriteRule
 
@@ -51,6 +52,10 @@ type FallbackOptions struct {
 	// Accept values that qualify as HTML (default: ["text/html", "*/*"])
 	HTMLAcceptHeaders []string
 
+	// If true, paths served by a clean URL page (/about for about.html) are
+	// not rewritten
+	CleanURLs bool
+
 	// Enable debug logging
 	Verbose bool
 }
@@ -64,8 +69,8 @@ type RewriteContext struct {
 type RewriteFunc func(ctx RewriteContext) string
 
 // RewriteRule defines a URL rewrite mapping.
-// To can be either a string (returned as-is) or a RewriteFunc (called with
-// context).
+// To can be either a string, where `$1` refers to the first capture group of
+// From, or a RewriteFunc (called with context).
 type RewriteRule struct {
 	From *regexp.Regexp // Pattern to match against the request path
 	To   any            // string or RewriteFunc
@@ -86,34 +91,28 @@ func CORS() app.HandlerFunc {
 	}
 }
 
+// LogMiddleware logs requests once they are handled, along with the subject
+// of the client certificate (with mutual TLS).
 func LogMiddleware(enabled bool) app.HandlerFunc {
 	return func(c context.Context, ctx *app.RequestContext) {
-		if enabled {
-			log.Info().Str("scope", "access-log").
-				Str("method", string(ctx.Method())).
-				Str("path", string(ctx.Path()))
+		if !enabled {
+			ctx.Next(c)
+			return
 		}
-		ctx.Next(c)
-	}
-}
 
-func BrotliMiddleware() app.HandlerFunc {
-	return func(c context.Context, ctx *app.RequestContext) {
-		urlPath := string(ctx.Path())
+		start := time.Now()
+		ctx.Next(c)
 
-		if strings.HasSuffix(strings.ToLower(urlPath), ".br") {
-			// get the real file extension before .br
-			realName := urlPath[:len(urlPath)-3]
-			realExt := filepath.Ext(realName)
-			contentType := mime.TypeByExtension(realExt)
-			if contentType == "" {
-				contentType = "application/octet-stream"
-			}
-			ctx.Header("Content-Type", contentType)
-			ctx.Header("Content-Encoding", "br")
+		event := log.Info().Str("scope", "access-log").
+			Str("method", string(ctx.Method())).
+			Str("path", string(ctx.Path())).
+			Int("status", ctx.Response.StatusCode()).
+			Str("remote", ctx.ClientIP()).
+			Dur("duration", time.Since(start))
+		if subject := ClientSubject(ctx); subject != "" {
+			event = event.Str("client", subject)
 		}
-
-		ctx.Next(c)
+		event.Msg("")
 	}
 }
 
@@ -124,15 +123,41 @@ func BrotliMiddleware() app.HandlerFunc {
 //  2. Client must send an Accept header
 //  3. Skip if Accept prefers application/json
 //  4. Only rewrite if Accept includes text/html
-//  5. Apply custom rewrite rules first (if any)
-//  6. Dot Rule: if the path's last segment contains a dot, treat it as a
+//  5. Apply custom rewrite rules first (if any), to paths that neither
+//     exist on disk nor have been rewritten by a _redirects rule
+//  6. Skip if a clean URL page serves the path (with CleanURLs)
+//  7. Dot Rule: if the path's last segment contains a dot, treat it as a
 //     file → skip
-//  7. Otherwise, rewrite to the fallback index
-func HistoryFallbackMiddleware(dir string, opts FallbackOptions) app.HandlerFunc {
-	if opts.Index == "" {
+//  8. Otherwise, rewrite to the fallback index of the longest matching
+//     prefix, or the default one
+//
+// It fails if a rewrite rule has no From pattern, or a To that is neither a
+// string nor a RewriteFunc.
+func HistoryFallbackMiddleware(dir string, opts FallbackOptions) (app.HandlerFunc, error) {
+	if opts.Index == "" && len(opts.Indexes) == 0 {
 		opts.Index = "/index.html"
 	}
 
+	for i, rule := range opts.Rewrites {
+		if rule.From == nil {
+			return nil, fmt.Errorf("rewrite rule #%d: missing From pattern", i+1)
+		}
+		switch rule.To.(type) {
+		case string, RewriteFunc:
+		default:
+			return nil, fmt.Errorf("rewrite rule %q: To must be string or RewriteFunc, got %T", rule.From, rule.To)
+		}
+	}
+
+	// longest prefix first
+	prefixes := make([]string, 0, len(opts.Indexes))
+	for prefix := range opts.Indexes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(strings.TrimSuffix(prefixes[i], "/")) > len(strings.TrimSuffix(prefixes[j], "/"))
+	})
+
 	logger := func(format string, args ...any) {
 		if opts.Verbose {
 			log.Trace().Str("scope", "history-fallback").Msgf(format, args...)
@@ -180,11 +205,25 @@ func HistoryFallbackMiddleware(dir string, opts FallbackOptions) app.HandlerFunc
 		}
 		pathname := parsedURL.Path
 
-		// 5. Check custom rewrite rules
-		for _, rule := range opts.Rewrites {
+		// 5. Check custom rewrite rules, unless the path exists or has been
+		//    rewritten by a _redirects rule already
+		rewrites := opts.Rewrites
+		if ctx.GetBool(rewrittenKey) {
+			rewrites = nil
+		} else if absPath, ok := resolvePath(dir, parsedURL.EscapedPath()); ok && len(rewrites) > 0 {
+			if _, err := os.Stat(absPath); err == nil {
+				rewrites = nil
+			}
+		}
+		for _, rule := range rewrites {
 			matches := rule.From.FindStringSubmatch(pathname)
 			if matches != nil {
-				rewriteTarget := evaluateRewriteRule(parsedURL, matches, rule.To)
+				rewriteTarget, err := evaluateRewriteRule(parsedURL, matches, rule.To)
+				if err != nil {
+					logger("Not rewriting %s %s — %v", method, reqURL, err)
+					ctx.Next(c)
+					return
+				}
 				if len(rewriteTarget) > 0 && rewriteTarget[0] != '/' {
 					logger("Warning: non-absolute rewrite target %q for URL %s", rewriteTarget, reqURL)
 				}
@@ -195,7 +234,17 @@ func HistoryFallbackMiddleware(dir string, opts FallbackOptions) app.HandlerFunc
 			}
 		}
 
-		// 6. Dot Rule: if the last segment of the path contains a dot,
+		// 6. Skip if a clean URL page exists
+		if opts.CleanURLs {
+			page := filepath.Join(dir, filepath.FromSlash(pathname)) + ".html"
+			if info, err := os.Stat(page); err == nil && !info.IsDir() && pathname != "/" {
+				logger("Not rewriting %s %s — clean URL page exists.", method, reqURL)
+				ctx.Next(c)
+				return
+			}
+		}
+
+		// 7. Dot Rule: if the last segment of the path contains a dot,
 		//    assume it's a file
 		if !opts.DisableDotRule {
 			lastSlash := strings.LastIndex(pathname, "/")
@@ -207,21 +256,32 @@ func HistoryFallbackMiddleware(dir string, opts FallbackOptions) app.HandlerFunc
 			}
 		}
 
-		// 7. Rewrite to fallback index
+		// 8. Rewrite to the fallback index of the longest matching prefix
 		rewriteTarget := opts.Index
+		for _, prefix := range prefixes {
+			if _, ok := matchPrefix(pathname, prefix); ok {
+				rewriteTarget = opts.Indexes[prefix]
+				break
+			}
+		}
+		if rewriteTarget == "" {
+			logger("Not rewriting %s %s — no fallback for the path.", method, reqURL)
+			ctx.Next(c)
+			return
+		}
 		logger("Rewriting %s %s to %s", method, reqURL, rewriteTarget)
 
 		// Serve the fallback file directly
 		fallbackPath := filepath.Join(dir, rewriteTarget)
 		if _, err := os.Stat(fallbackPath); err == nil {
-			ctx.File(fallbackPath)
+			serveFile(ctx, fallbackPath)
 			ctx.Abort()
 			return
 		}
 
 		// Fallback file not found, continue to next handler
 		ctx.Next(c)
-	}
+	}, nil
 }
 
 // acceptsHTML checks if the Accept header includes any of the HTML accept
@@ -239,88 +299,300 @@ func acceptsHTML(accept string, htmlAcceptHeaders []string) bool {
 }
 
 // evaluateRewriteRule resolves the rewrite target.
-//   - string  → returned as-is
+//   - string  → returned with its capture group references expanded
 //   - RewriteFunc → called with {parsedURL, match} context
-func evaluateRewriteRule(parsedURL *url.URL, matches []string, to any) string {
+func evaluateRewriteRule(parsedURL *url.URL, matches []string, to any) (string, error) {
 	switch target := to.(type) {
 	case string:
-		return target
+		return expandMatches(target, matches), nil
 	case RewriteFunc:
 		return target(RewriteContext{
 			ParsedURL: parsedURL,
 			Match:     matches,
-		})
+		}), nil
 	default:
-		panic(fmt.Sprintf("rewrite rule To must be string or RewriteFunc, got %T", to))
+		return "", fmt.Errorf("rewrite rule To must be string or RewriteFunc, got %T", to)
+	}
+}
+
+// expandMatches replaces `$1` and `${1}` in target with the submatches,
+// `$0` being the whole match.
+func expandMatches(target string, matches []string) string {
+	if !strings.Contains(target, "$") {
+		return target
+	}
+	return config.CaptureRefPattern.ReplaceAllStringFunc(target, func(ref string) string {
+		n, _ := strconv.Atoi(strings.Trim(ref, "${}"))
+		if n < len(matches) {
+			return matches[n]
+		}
+		return ref
+	})
+}
+
+// resolvePath maps a request path to a file path inside root. It reports
+// false if the path would escape the root directory.
+func resolvePath(root, urlPath string) (absPath string, ok bool) {
+	// Decode URL path
+	decoded, err := url.PathUnescape(urlPath)
+	if err != nil {
+		decoded = urlPath
+	}
+
+	// Security: prevent path traversal
+	cleanPath := filepath.Clean("/" + decoded)
+	absPath = filepath.Join(root, cleanPath)
+
+	// Ensure we don't escape the root directory
+	absDir, _ := filepath.Abs(root)
+	absReq, _ := filepath.Abs(absPath)
+	sep := string(filepath.Separator)
+	if absReq != absDir && !strings.HasPrefix(absReq, strings.TrimSuffix(absDir, sep)+sep) {
+		return "", false
 	}
+
+	return absReq, true
+}
+
+// controlFiles are the files of the root directory configuring the server,
+// they are neither served nor listed.
+var controlFiles = append(slices.Clone(config.FileNames), RedirectsFile, HeadersFile)
+
+// isControlFile reports whether the request path urlPath names one of the
+// controlFiles, or a precompressed sidecar of one.
+func isControlFile(urlPath string) bool {
+	if decoded, err := url.PathUnescape(urlPath); err == nil {
+		urlPath = decoded
+	}
+	urlPath = filepath.ToSlash(filepath.Clean("/" + urlPath))
+
+	for _, sidecar := range sidecarEncodings {
+		if len(urlPath) > len(sidecar.ext) && strings.EqualFold(urlPath[len(urlPath)-len(sidecar.ext):], sidecar.ext) {
+			urlPath = urlPath[:len(urlPath)-len(sidecar.ext)]
+			break
+		}
+	}
+
+	return slices.ContainsFunc(controlFiles, func(name string) bool {
+		return strings.EqualFold(urlPath, "/"+name)
+	})
+}
+
+// HideControlFiles responds 404 to requests for the controlFiles, ahead of
+// every other middleware and route. shares are the URL prefixes under which
+// the root directory is shared as well, e.g. over WebDAV.
+func HideControlFiles(shares ...string) app.HandlerFunc {
+	return func(c context.Context, ctx *app.RequestContext) {
+		urlPath := string(ctx.Path())
+
+		hidden := isControlFile(urlPath)
+		for _, prefix := range shares {
+			if rest, ok := matchPrefix(urlPath, prefix); ok && isControlFile(rest) {
+				hidden = true
+			}
+		}
+		if hidden {
+			writeError(ctx, consts.StatusNotFound, msgNotFound)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next(c)
+	}
+}
+
+// Directory listing formats
+const (
+	listingHTML   = "html"
+	listingJSON   = "json"
+	listingNDJSON = "ndjson"
+)
+
+// listingFormat negotiates the directory listing format, the `format` query
+// parameter wins over the Accept header.
+func listingFormat(c *app.RequestContext) string {
+	switch format := c.Query("format"); format {
+	case listingHTML, listingJSON, listingNDJSON:
+		return format
+	}
+
+	accept := string(c.GetHeader("Accept"))
+	switch {
+	case strings.Contains(accept, "application/x-ndjson"), strings.Contains(accept, "application/ndjson"):
+		return listingNDJSON
+	case strings.HasPrefix(accept, "application/json"):
+		return listingJSON
+	default:
+		return listingHTML
+	}
+}
+
+// listOptions reads the sorting, filtering and pagination query parameters
+// `sort`, `order`, `q`, `page` and `per`. Invalid values are ignored.
+func listOptions(c *app.RequestContext) ListOptions {
+	opts := ListOptions{
+		Desc:   c.Query("order") == "desc",
+		Filter: c.Query("q"),
+		Page:   1,
+		Per:    DefaultPerPage,
+	}
+
+	switch sortBy := c.Query("sort"); sortBy {
+	case SortByName, SortByExt, SortBySize, SortByMTime:
+		opts.Sort = sortBy
+	}
+	if page, err := strconv.Atoi(c.Query("page")); err == nil && page > 0 {
+		opts.Page = page
+	}
+	if per, err := strconv.Atoi(c.Query("per")); err == nil && per >= 0 {
+		opts.Per = min(per, MaxPerPage)
+	}
+
+	return opts
+}
+
+// writeListing writes the directory listing as a JSON document, or as one
+// JSON entry per line for NDJSON.
+func writeListing(c *app.RequestContext, data *DirListData, format string) {
+	listing := data.Listing()
+
+	if format == listingJSON {
+		c.JSON(consts.StatusOK, listing)
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range listing.Entries {
+		_ = enc.Encode(entry)
+	}
+	c.Data(consts.StatusOK, "application/x-ndjson", buf.Bytes())
 }
 
 // StaticFileHandler serves static files with directory listing fallback
 func StaticFileHandler(cfg *config.Config) app.HandlerFunc {
 	return func(ctx context.Context, c *app.RequestContext) {
 		urlPath := string(c.Path())
+		hasSlash := urlPath != "/" && strings.HasSuffix(urlPath, "/")
 
-		// Decode URL path
-		decoded, err := url.PathUnescape(urlPath)
-		if err != nil {
-			decoded = urlPath
+		absPath, ok := resolvePath(cfg.Dir, urlPath)
+		if !ok {
+			writeError(c, consts.StatusForbidden, msgForbidden)
+			return
 		}
-
-		// Security: prevent path traversal
-		cleanPath := filepath.Clean(decoded)
-		absPath := filepath.Join(cfg.Dir, cleanPath)
-
-		// Ensure we don't escape the root directory
-		absDir, _ := filepath.Abs(cfg.Dir)
-		absReq, _ := filepath.Abs(absPath)
-		if !strings.HasPrefix(absReq, absDir) {
-			c.String(consts.StatusForbidden, "403 Forbidden")
+		if isControlFile(urlPath) {
+			writeError(c, consts.StatusNotFound, msgNotFound)
 			return
 		}
 
 		info, err := os.Stat(absPath)
 		if err != nil {
-			c.String(consts.StatusNotFound, "404 Not Found")
+			// Clean URLs: /about serves about.html
+			if page := cleanURLPage(cfg, absPath); page != "" {
+				if hasSlash && cfg.TrailingSlash == config.TrailingSlashStrip {
+					redirect(c, strings.TrimSuffix(urlPath, "/"))
+					return
+				}
+				serveFile(c, page)
+				return
+			}
+			writeError(c, consts.StatusNotFound, msgNotFound)
 			return
 		}
 
 		// If it's a directory
 		if info.IsDir() {
-			// Ensure trailing slash for directories
-			if !strings.HasSuffix(urlPath, "/") {
-				c.Redirect(consts.StatusMovedPermanently, []byte(urlPath+"/"))
+			// Apply the trailing slash policy
+			switch {
+			case cfg.TrailingSlash == config.TrailingSlashAdd && !strings.HasSuffix(urlPath, "/"):
+				redirect(c, urlPath+"/")
+				return
+			case cfg.TrailingSlash == config.TrailingSlashStrip && hasSlash:
+				redirect(c, strings.TrimSuffix(urlPath, "/"))
 				return
 			}
 
-			// Try to serve index.html
-			indexPath := filepath.Join(absPath, "index.html")
-			if _, err := os.Stat(indexPath); err == nil {
-				c.File(indexPath)
+			// Download the directory as an archive
+			if format := c.Query("download"); format != "" {
+				serveArchive(c, absPath, urlPath, format)
 				return
 			}
 
+			// Machine-readable listings take precedence over index files
+			format := listingFormat(c)
+
+			// Try to serve an index file
+			if format == listingHTML {
+				for _, index := range cfg.IndexFiles {
+					indexPath := filepath.Join(absPath, index)
+					if fileInfo, err := os.Stat(indexPath); err == nil && !fileInfo.IsDir() {
+						serveFile(c, indexPath)
+						return
+					}
+				}
+			}
+
 			// Generate directory listing
-			data, err := BuildDirListData(absPath, urlPath)
+			data, err := BuildDirListData(absPath, urlPath, listOptions(c))
 			if err != nil {
-				c.String(consts.StatusInternalServerError, "Error listing directory: %v", err)
+				writeError(c, consts.StatusInternalServerError, "Cannot list the directory: %v", err)
 				return
 			}
+			data.Upload = cfg.Upload
 
-			c.HTML(consts.StatusOK, "index.gohtml", data)
+			c.Response.Header.Set("Vary", "Accept")
+			if format != listingHTML {
+				writeListing(c, data, format)
+				return
+			}
+
+			c.HTML(consts.StatusOK, listingTemplate, data)
 			return
 		}
 
+		// Clean URLs: redirect /about.html to /about, and /docs/index.html
+		// to /docs/
+		if cfg.CleanURLsRedirect && strings.HasSuffix(urlPath, ".html") {
+			if target := cleanURL(cfg, urlPath); target != urlPath {
+				redirect(c, target)
+				return
+			}
+		}
+
 		// Serve the file
-		c.FileFromFS(absPath, nonCacheRootFS)
+		serveFile(c, absPath)
 	}
 }
 
-func RegisterTemplate(h *server.Hertz) {
-	tmpl, err := template.New("hertz-html-engine").ParseFS(templateFS, "templates/*")
-	if err != nil {
-		log.Error().Err(err).Msg("cannot load html templates")
-		os.Exit(1)
+// cleanURLPage returns the HTML page serving the extensionless absPath when
+// clean URLs are enabled, or an empty string.
+func cleanURLPage(cfg *config.Config, absPath string) string {
+	if !cfg.CleanURLs {
+		return ""
 	}
+	page := strings.TrimSuffix(absPath, string(filepath.Separator)) + ".html"
+	if info, err := os.Stat(page); err == nil && !info.IsDir() {
+		return page
+	}
+	return ""
+}
 
-	h.SetHTMLTemplate(tmpl)
+// cleanURL returns the canonical clean URL of the .html urlPath.
+func cleanURL(cfg *config.Config, urlPath string) string {
+	dir, name := path.Split(urlPath)
+	if slices.Contains(cfg.IndexFiles, name) {
+		if dir == "/" || cfg.TrailingSlash != config.TrailingSlashStrip {
+			return dir
+		}
+		return strings.TrimSuffix(dir, "/")
+	}
+	return strings.TrimSuffix(urlPath, ".html")
+}
+
+// redirect permanently redirects to urlPath, keeping the query string.
+func redirect(c *app.RequestContext, urlPath string) {
+	if query := c.Request.URI().QueryString(); len(query) > 0 {
+		urlPath += "?" + string(query)
+	}
+	c.Redirect(consts.StatusMovedPermanently, []byte(urlPath))
 }
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app/server"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/config"
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// ListingTemplateFile is the listing template picked up from the served
+// directory, relative to its root.
+const ListingTemplateFile = ".anywhere/listing.gohtml"
+
+// listingTemplate is the name of the template rendering directory listings.
+var listingTemplate = "index.gohtml"
+
+// RegisterTemplate loads the built-in templates and selects the directory
+// listing template, in order of precedence: --listing-template, the
+// .anywhere/listing.gohtml file of the served directory, the --theme and the
+// default look. Custom templates may use the built-in partials ("style",
+// "toolbar", "file-table", "pager", "listing", ...). Any template error is
+// reported at startup instead of on the first request.
+func RegisterTemplate(h *server.Hertz, cfg *config.Config) {
+	tmpl, err := template.New("hertz-html-engine").ParseFS(templateFS, "templates/*.gohtml", "templates/themes/*.gohtml")
+	if err != nil {
+		log.Error().Err(err).Msg("cannot load html templates")
+		os.Exit(1)
+	}
+
+	name := "index.gohtml"
+	if cfg.Theme != "" && cfg.Theme != "default" {
+		name = cfg.Theme + ".gohtml"
+	}
+
+	custom := cfg.ListingTemplate
+	if custom == "" {
+		if path := filepath.Join(cfg.Dir, ListingTemplateFile); fileExists(path) {
+			custom = path
+		}
+	}
+	if custom != "" {
+		content, err := os.ReadFile(custom)
+		if err != nil {
+			log.Error().Str("scope", "template").Err(err).Msgf("cannot read listing template %s", custom)
+			os.Exit(1)
+		}
+		if _, err = tmpl.New(custom).Parse(string(content)); err != nil {
+			log.Error().Str("scope", "template").Err(err).Msgf("invalid listing template %s", custom)
+			os.Exit(1)
+		}
+		name = custom
+	}
+
+	// Render sample data once, so that references to unknown fields or
+	// templates fail now rather than on every listing request.
+	if err = tmpl.ExecuteTemplate(io.Discard, name, sampleListing()); err != nil {
+		log.Error().Str("scope", "template").Err(err).Msgf("cannot render listing template %s", name)
+		os.Exit(1)
+	}
+
+	listingTemplate = name
+	h.SetHTMLTemplate(tmpl)
+}
+
+// sampleListing returns listing data exercising every field of the templates.
+func sampleListing() *DirListData {
+	now := time.Now()
+	return &DirListData{
+		Path:      "/docs/",
+		Parent:    "/",
+		HasParent: true,
+		Files: []FileInfo{
+			{Name: "images", URL: "/docs/images/", Size: "-", ModTime: now.Format("2006-01-02 15:04:05"), IsDir: true, Icon: "📁", ModifiedAt: now, Mode: os.ModeDir | 0o755},
+			{Name: "logo.png", URL: "/docs/logo.png", Size: "1.0 KB", Ext: "png", ModTime: now.Format("2006-01-02 15:04:05"), Icon: "🖼️", SizeBytes: 1024, ModifiedAt: now, Mode: 0o644, MIME: "image/png"},
+		},
+		Upload:  true,
+		Options: ListOptions{Page: 1, Per: DefaultPerPage},
+		Total:   2,
+		Pages:   1,
+	}
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/config"
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+var errTooLarge = errors.New("request body too large")
+
+// limitedReader fails with errTooLarge instead of returning io.EOF when more
+// than n bytes are read.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// UploadHandler stores uploaded files in the served tree. It accepts
+//
+//   - POST <dir>/ with a multipart form, every file part is saved into dir
+//   - PUT <path> with the raw file content as the body, missing parent
+//     directories are created
+//
+// Files are written to a temporary file next to the target first and renamed
+// into place, so readers never see partial content. Hidden paths and the
+// files configuring the server cannot be written, and uploads from other
+// origins are refused.
+func UploadHandler(cfg *config.Config) app.HandlerFunc {
+	limit := int64(cfg.UploadLimit) * MB
+
+	return func(c context.Context, ctx *app.RequestContext) {
+		urlPath := string(ctx.Path())
+
+		// the CORS middleware allows any origin to read responses, which
+		// must not extend to writes
+		ctx.Response.Header.Del("Access-Control-Allow-Origin")
+		if !sameOrigin(ctx) {
+			writeError(ctx, consts.StatusForbidden, "Uploads from other origins are not allowed.")
+			return
+		}
+
+		absPath, ok := resolvePath(cfg.Dir, urlPath)
+		if !ok || hasHiddenSegment(urlPath) || isControlFile(urlPath) {
+			writeError(ctx, consts.StatusForbidden, msgForbidden)
+			return
+		}
+
+		if int64(ctx.Request.Header.ContentLength()) > limit {
+			writeError(ctx, consts.StatusRequestEntityTooLarge, "The upload exceeds the limit of %d MB.", cfg.UploadLimit)
+			return
+		}
+		body := &limitedReader{r: requestBody(ctx), n: limit}
+
+		var (
+			saved []string
+			err   error
+		)
+		if string(ctx.Method()) == consts.MethodPut {
+			if strings.HasSuffix(urlPath, "/") {
+				writeError(ctx, consts.StatusMethodNotAllowed, "Files cannot be uploaded to a directory URL with PUT.")
+				return
+			}
+
+			var created bool
+			created, err = uploadRaw(absPath, body)
+			if err == nil {
+				saved = append(saved, urlPath)
+				if !created {
+					ctx.SetStatusCode(consts.StatusNoContent)
+					logUpload(cfg.EnableLog, saved)
+					return
+				}
+			}
+		} else {
+			saved, err = uploadMultipart(ctx, absPath, urlPath, body)
+		}
+
+		switch {
+		case errors.Is(err, errTooLarge):
+			writeError(ctx, consts.StatusRequestEntityTooLarge, "The upload exceeds the limit of %d MB.", cfg.UploadLimit)
+		case errors.Is(err, os.ErrNotExist):
+			writeError(ctx, consts.StatusNotFound, "The target directory does not exist.")
+		case err != nil:
+			writeError(ctx, consts.StatusBadRequest, "Upload failed: %v", err)
+		default:
+			logUpload(cfg.EnableLog, saved)
+			ctx.JSON(consts.StatusCreated, utils.H{"files": saved})
+		}
+	}
+}
+
+// sameOrigin reports whether the request comes from a page of this server,
+// or from a client that sends no Origin header, like curl.
+func sameOrigin(ctx *app.RequestContext) bool {
+	origin := string(ctx.GetHeader("Origin"))
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, string(ctx.Host()))
+}
+
+// hasHiddenSegment reports whether a segment of the request path urlPath
+// starts with a dot, which also covers `.` and `..`.
+func hasHiddenSegment(urlPath string) bool {
+	if decoded, err := url.PathUnescape(urlPath); err == nil {
+		urlPath = decoded
+	}
+	for _, segment := range strings.FieldsFunc(urlPath, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if isHidden(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBody returns the request body reader, small bodies are read by the
+// server before the handler runs even in stream mode.
+func requestBody(ctx *app.RequestContext) io.Reader {
+	if ctx.Request.IsBodyStream() {
+		return ctx.RequestBodyStream()
+	}
+	return bytes.NewReader(ctx.Request.Body())
+}
+
+func logUpload(enabled bool, files []string) {
+	if enabled {
+		log.Info().Str("scope", "upload").Strs("files", files).Msg("files uploaded")
+	}
+}
+
+// uploadRaw writes r to absPath, it reports whether the file was created.
+func uploadRaw(absPath string, r io.Reader) (created bool, err error) {
+	if info, err := os.Stat(absPath); err == nil && info.IsDir() {
+		return false, errors.New("cannot PUT a directory")
+	} else if err != nil {
+		created = true
+	}
+
+	if err = os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return false, err
+	}
+
+	return created, writeFileAtomic(absPath, r)
+}
+
+// uploadMultipart saves every file part of the multipart form into the
+// directory absDir, it returns the URL paths of the saved files.
+func uploadMultipart(ctx *app.RequestContext, absDir, urlDir string, r io.Reader) ([]string, error) {
+	if info, err := os.Stat(absDir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, errors.New("uploads must be posted to a directory")
+	}
+
+	boundary := string(ctx.Request.Header.MultipartFormBoundary())
+	if boundary == "" {
+		return nil, errors.New("request is not a multipart form")
+	}
+
+	var saved []string
+	mr := multipart.NewReader(r, boundary)
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return saved, err
+		}
+
+		if part.FileName() == "" {
+			continue // not a file field
+		}
+
+		name := filepath.Base(filepath.Clean("/" + part.FileName()))
+		if name == string(filepath.Separator) || isHidden(name) || isControlFile(path.Join(urlDir, name)) {
+			return saved, fmt.Errorf("invalid file name %q", part.FileName())
+		}
+
+		if err = writeFileAtomic(filepath.Join(absDir, name), part); err != nil {
+			return saved, err
+		}
+		saved = append(saved, path.Join(urlDir, name))
+	}
+
+	return saved, nil
+}
+
+// writeFileAtomic writes r to a temporary file in the target directory and
+// renames it to name once complete.
+func writeFileAtomic(name string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".anywhere-upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // no-op after rename
+
+	if _, err = io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/adaptor"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"golang.org/x/net/webdav"
+
+	"github.com/AyakuraYuki/go-anywhere/internal/log"
+)
+
+// WebDAVMethods are the methods routed to the WebDAV handler.
+var WebDAVMethods = []string{
+	consts.MethodGet, consts.MethodHead, consts.MethodOptions,
+	consts.MethodPut, consts.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// webdavWriteMethods are rejected when the share is read-only.
+var webdavWriteMethods = map[string]bool{
+	consts.MethodPut:    true,
+	consts.MethodDelete: true,
+	"PROPPATCH":         true,
+	"MKCOL":             true,
+	"COPY":              true,
+	"MOVE":              true,
+	"LOCK":              true,
+	"UNLOCK":            true,
+}
+
+// readOnlyFS rejects every operation of the wrapped file system that would
+// modify it.
+type readOnlyFS struct {
+	webdav.FileSystem
+}
+
+func (fs readOnlyFS) Mkdir(context.Context, string, os.FileMode) error { return os.ErrPermission }
+func (fs readOnlyFS) RemoveAll(context.Context, string) error          { return os.ErrPermission }
+func (fs readOnlyFS) Rename(context.Context, string, string) error     { return os.ErrPermission }
+
+func (fs readOnlyFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	return fs.FileSystem.OpenFile(ctx, name, flag, perm)
+}
+
+// hidingFS keeps the control files and dotfiles of the wrapped file system
+// out of reach, as if they did not exist.
+type hidingFS struct {
+	webdav.FileSystem
+}
+
+// hiddenPath reports whether the share path name is a control file or has a
+// hidden segment.
+func hiddenPath(name string) bool {
+	return isControlFile(name) || hasHiddenSegment(name)
+}
+
+func (fs hidingFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if hiddenPath(name) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs hidingFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if hiddenPath(name) {
+		return nil, os.ErrNotExist
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return hidingFile{File: f, name: name}, nil
+}
+
+func (fs hidingFS) RemoveAll(ctx context.Context, name string) error {
+	if hiddenPath(name) {
+		return os.ErrNotExist
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fs hidingFS) Rename(ctx context.Context, oldName, newName string) error {
+	if hiddenPath(oldName) || hiddenPath(newName) {
+		return os.ErrNotExist
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs hidingFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if hiddenPath(name) {
+		return nil, os.ErrNotExist
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+// hidingFile leaves the hidden entries out of directory listings.
+type hidingFile struct {
+	webdav.File
+	name string
+}
+
+func (f hidingFile) Readdir(count int) ([]os.FileInfo, error) {
+	for {
+		infos, err := f.File.Readdir(count)
+		infos = slices.DeleteFunc(infos, func(info os.FileInfo) bool {
+			return hiddenPath(path.Join(f.name, info.Name()))
+		})
+		// a positive count must not end with an empty page before the
+		// end of the directory
+		if len(infos) > 0 || err != nil || count <= 0 {
+			return infos, err
+		}
+	}
+}
+
+// WebDAV shares dir under the URL prefix. The share is read-only unless
+// writable is set, webdav.Dir keeps every path confined to dir. Control
+// files and dotfiles are neither listed nor accessible.
+func WebDAV(dir, prefix string, writable, verbose bool) app.HandlerFunc {
+	var fs webdav.FileSystem = hidingFS{webdav.Dir(dir)}
+	if !writable {
+		fs = readOnlyFS{fs}
+	}
+
+	h := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && verbose {
+				log.Warn().Str("scope", "webdav").Err(err).Msgf("%s %s", r.Method, r.URL.Path)
+			}
+		},
+	}
+
+	dav := adaptor.HertzHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		// The adaptor only sends headers on WriteHeader or Write, responses
+		// without a body (e.g. OPTIONS) would lose their Allow and DAV
+		// headers otherwise. No-op if the response was written already.
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return func(c context.Context, ctx *app.RequestContext) {
+		if !writable && webdavWriteMethods[string(ctx.Method())] {
+			writeError(ctx, consts.StatusForbidden, "The WebDAV share is read-only.")
+			return
+		}
+		dav(c, ctx)
+	}
+}